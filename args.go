@@ -0,0 +1,315 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArgType says how an argument or flag value is parsed and validated.
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgDuration
+	ArgUser
+	ArgChannel
+	ArgRole
+	ArgURL
+	// ArgBool flags take no value, they are either given or not.
+	ArgBool
+)
+
+var (
+	userMentionPattern    = regexp.MustCompile(`^<@!?(\d+)>$`)
+	channelMentionPattern = regexp.MustCompile(`^<#(\d+)>$`)
+	roleMentionPattern    = regexp.MustCompile(`^<@&(\d+)>$`)
+	snowflakePattern      = regexp.MustCompile(`^\d{15,20}$`)
+	clockPattern          = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{2})$`)
+
+	// Quotes that group words, mapped to the quote closing them.
+	quotePairs = map[rune]rune{'"': '"', '“': '”', '«': '»'}
+)
+
+// ArgError is a usage mistake. It is shown to the author next to the
+// command's usage.
+type ArgError struct {
+	Arg string
+	Err error
+}
+
+func (e *ArgError) Error() string {
+	if e.Arg == "" {
+		return e.Err.Error()
+	}
+	return e.Arg + " " + e.Err.Error()
+}
+
+// parsedArgs holds argument and flag values by name.
+type parsedArgs map[string]interface{}
+
+// tokenize splits a command line on whitespace. Text in quotes stays one
+// token, inside quotes a backslash escapes the next character.
+func tokenize(line string) ([]string, error) {
+	tokens, _, err := tokenizeWithOffsets(line)
+	return tokens, err
+}
+
+// tokenizeWithOffsets is tokenize that also says where in line each token
+// starts, so the rest of the line can be taken as it was typed.
+func tokenizeWithOffsets(line string) ([]string, []int, error) {
+	var tokens []string
+	var starts []int
+	var token strings.Builder
+	started := false
+	var closing rune
+	escaped := false
+
+	for i, r := range line {
+		if !started && !(r == ' ' || r == '\t' || r == '\n' || r == '\r') {
+			starts = append(starts, i)
+		}
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case closing != 0 && r == '\\':
+			escaped = true
+		case closing != 0 && r == closing:
+			closing = 0
+		case closing != 0:
+			token.WriteRune(r)
+		case quotePairs[r] != 0:
+			closing = quotePairs[r]
+			started = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if started {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				started = false
+			}
+		default:
+			token.WriteRune(r)
+			started = true
+		}
+	}
+	if closing != 0 {
+		return nil, nil, &ArgError{Err: localErr("args.unclosed_quote")}
+	}
+	if started {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, starts, nil
+}
+
+// parseArgs matches tokens against the arguments and flags declared by
+// cmd. Flags are "--name value", "--name=value" or just "--name" for
+// ArgBool, and can go anywhere. An optional argument that doesn't accept
+// a token leaves it to the arguments after it.
+func parseArgs(cmd *Command, tokens []string) (parsedArgs, []string, error) {
+	return parseTokens(cmd, tokens, nil)
+}
+
+// parseTokens is parseArgs for a line that was typed. raw returns the line
+// from the i-th token on as typed, which a Rest argument gets as long as
+// no flag comes after its start: newlines, spacing and quotes included.
+func parseTokens(cmd *Command, tokens []string, raw func(i int) string) (parsedArgs, []string, error) {
+	values := parsedArgs{}
+	var positional []string
+	var positionalAt []int
+	lastFlag := -1
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if !strings.HasPrefix(token, "--") || len(token) == 2 {
+			positional = append(positional, token)
+			positionalAt = append(positionalAt, i)
+			continue
+		}
+		lastFlag = i
+		name, value := token[2:], ""
+		hasValue := false
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		flag := cmd.flag(name)
+		if flag == nil {
+			return nil, nil, &ArgError{Arg: "--" + name, Err: localErr("args.unknown_flag")}
+		}
+		if flag.Type == ArgBool && !hasValue {
+			values[flag.Name] = true
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(tokens) {
+				return nil, nil, &ArgError{Arg: "--" + name, Err: localErr("args.needs_value")}
+			}
+			i++
+			value = tokens[i]
+		}
+		v, err := flag.parse(value)
+		if err != nil {
+			return nil, nil, &ArgError{Arg: "--" + name, Err: err}
+		}
+		values[flag.Name] = v
+	}
+
+	next := 0
+	for n, arg := range cmd.Args {
+		if next == len(positional) {
+			if !arg.Optional {
+				return nil, nil, &ArgError{Arg: arg.Name, Err: localErr("args.missing")}
+			}
+			continue
+		}
+		if arg.Rest {
+			if from := positionalAt[next]; raw != nil && from > lastFlag {
+				values[arg.Name] = raw(from)
+			} else {
+				values[arg.Name] = strings.Join(positional[next:], " ")
+			}
+			next = len(positional)
+			break
+		}
+		v, err := arg.parse(positional[next])
+		if err != nil {
+			if arg.Optional && n+1 < len(cmd.Args) {
+				continue
+			}
+			return nil, nil, &ArgError{Arg: arg.Name, Err: err}
+		}
+		values[arg.Name] = v
+		next++
+	}
+	if next < len(positional) {
+		return nil, nil, &ArgError{Err: localErr("args.too_many")}
+	}
+	return values, positional, nil
+}
+
+func (cmd *Command) flag(name string) *Arg {
+	for i := range cmd.Flags {
+		if cmd.Flags[i].Name == name {
+			return &cmd.Flags[i]
+		}
+	}
+	return nil
+}
+
+// parse converts a single token to the argument's type.
+func (arg *Arg) parse(token string) (interface{}, error) {
+	if len(arg.Choices) > 0 {
+		for _, choice := range arg.Choices {
+			if strings.EqualFold(token, choice) {
+				return choice, nil
+			}
+		}
+		return nil, localErr("args.choices", strings.Join(arg.Choices, ", "))
+	}
+
+	switch arg.Type {
+	case ArgInt:
+		// Ids are shown as #3 in lists, take them back the same way.
+		n, err := strconv.Atoi(strings.TrimPrefix(token, "#"))
+		if err != nil {
+			return nil, localErr("args.number")
+		}
+		if err := arg.checkRange(n, strconv.Itoa); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case ArgDuration:
+		d, err := parseDuration(token)
+		if err != nil {
+			return nil, err
+		}
+		seconds := func(n int) string { return (time.Duration(n) * time.Second).String() }
+		if err := arg.checkRange(int(d/time.Second), seconds); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case ArgUser:
+		return parseMention(token, userMentionPattern, "args.user")
+	case ArgChannel:
+		return parseMention(token, channelMentionPattern, "args.channel")
+	case ArgRole:
+		return parseMention(token, roleMentionPattern, "args.role")
+	case ArgURL:
+		// Discord users wrap links in <> to hide the preview.
+		link := strings.TrimSuffix(strings.TrimPrefix(token, "<"), ">")
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, localErr("args.link")
+		}
+		return link, nil
+	case ArgBool:
+		switch strings.ToLower(token) {
+		case "on", "true", "yes":
+			return true, nil
+		case "off", "false", "no":
+			return false, nil
+		}
+		return nil, localErr("args.on_off")
+	}
+	return token, nil
+}
+
+// checkRange applies Min and Max, both zero means any value goes.
+func (arg *Arg) checkRange(n int, format func(int) string) error {
+	switch {
+	case arg.Min == 0 && arg.Max == 0:
+		return nil
+	case arg.Max == 0 && n < arg.Min:
+		return localErr("args.at_least", format(arg.Min))
+	case arg.Max != 0 && (n < arg.Min || n > arg.Max):
+		return localErr("args.between", format(arg.Min), format(arg.Max))
+	}
+	return nil
+}
+
+// parseDuration takes Go durations (1m30s), plain seconds (90) and clock
+// times (1:30, 1:02:03).
+func parseDuration(token string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(token); err == nil {
+		if seconds <= 0 {
+			return 0, localErr("args.positive_duration")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if match := clockPattern.FindStringSubmatch(token); match != nil {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds, _ := strconv.Atoi(match[3])
+		if seconds >= 60 || (match[1] != "" && minutes >= 60) {
+			return 0, localErr("args.duration")
+		}
+		d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+		if d <= 0 {
+			return 0, localErr("args.positive_duration")
+		}
+		return d, nil
+	}
+	d, err := time.ParseDuration(token)
+	if err != nil {
+		return 0, localErr("args.duration")
+	}
+	if d <= 0 {
+		return 0, localErr("args.positive_duration")
+	}
+	return d, nil
+}
+
+// parseMention returns the id from a mention, raw ids are taken as well.
+// problem is the message key used when token is neither.
+func parseMention(token string, pattern *regexp.Regexp, problem string) (interface{}, error) {
+	if match := pattern.FindStringSubmatch(token); match != nil {
+		return match[1], nil
+	}
+	if snowflakePattern.MatchString(token) {
+		return token, nil
+	}
+	return nil, localErr(problem)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"lib  play   3", []string{"lib", "play", "3"}},
+		{`say "hello  world" now`, []string{"say", "hello  world", "now"}},
+		{`tag create "" empty`, []string{"tag", "create", "", "empty"}},
+		{`say “smart quotes” «ёлки»`, []string{"say", "smart quotes", "ёлки"}},
+		{`say "she said \"hi\""`, []string{"say", `she said "hi"`}},
+		{`say don't stop`, []string{"say", "don't", "stop"}},
+		{"say\tmulti\nline", []string{"say", "multi", "line"}},
+		{`say pre"fix and"post`, []string{"say", "prefix andpost"}},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.input)
+		if err != nil {
+			t.Errorf("tokenize(%q) error = %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := tokenize(`say "unterminated`); err == nil {
+		t.Error(`tokenize("say \"unterminated") error = nil, want an error`)
+	}
+}
+
+func TestParseTokensRaw(t *testing.T) {
+	cmd := &Command{
+		Args:  []Arg{{Name: "name"}, {Name: "text", Optional: true, Rest: true}},
+		Flags: []Arg{{Name: "embed", Type: ArgBool}},
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"rules  Be nice,\n\n  \"{user}\"!  ", "Be nice,\n\n  \"{user}\"!"},
+		{"--embed rules  two  spaces", "two  spaces"},
+		// A flag after the text can't be cut out of it as typed.
+		{"rules  two  spaces --embed", "two spaces"},
+		{"rules", ""},
+	}
+	for _, tt := range tests {
+		tokens, starts, err := tokenizeWithOffsets(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := parseTokens(cmd, tokens, func(i int) string {
+			return strings.TrimSpace(tt.line[starts[i]:])
+		})
+		if err != nil || got["text"] != nil && got["text"] != tt.want || got["text"] == nil && tt.want != "" {
+			t.Errorf("parseTokens(%q) text = %q, %v, want %q", tt.line, got["text"], err, tt.want)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	cmd := &Command{
+		Args: []Arg{
+			{Name: "rounds", Type: ArgInt, Optional: true, Min: 1, Max: 20},
+			{Name: "category", Optional: true},
+		},
+		Flags: []Arg{
+			{Name: "length", Type: ArgDuration, Max: 60},
+			{Name: "loud", Type: ArgBool},
+			{Name: "dj", Type: ArgUser},
+		},
+	}
+	tests := []struct {
+		name    string
+		tokens  []string
+		want    parsedArgs
+		wantErr string
+	}{
+		{name: "nothing", tokens: nil, want: parsedArgs{}},
+		{name: "int", tokens: []string{"5"}, want: parsedArgs{"rounds": 5}},
+		{name: "optional int skipped", tokens: []string{"rock"}, want: parsedArgs{"category": "rock"}},
+		{name: "both", tokens: []string{"3", "rock"}, want: parsedArgs{"rounds": 3, "category": "rock"}},
+		{name: "flags anywhere", tokens: []string{"--loud", "3", "--length", "30s", "rock"},
+			want: parsedArgs{"rounds": 3, "category": "rock", "loud": true, "length": 30 * time.Second}},
+		{name: "flag with equals", tokens: []string{"--length=1:00", "--dj=<@!123456789012345678>"},
+			want: parsedArgs{"length": time.Minute, "dj": "123456789012345678"}},
+		{name: "int out of range falls through", tokens: []string{"25"}, want: parsedArgs{"category": "25"}},
+		{name: "too many", tokens: []string{"3", "rock", "extra"}, wantErr: "too many arguments"},
+		{name: "unknown flag", tokens: []string{"--volume", "3"}, wantErr: "--volume is not a flag of this command"},
+		{name: "flag without value", tokens: []string{"--length"}, wantErr: "--length needs a value"},
+		{name: "flag over max", tokens: []string{"--length", "2m"}, wantErr: "--length must be between 0s and 1m0s"},
+		{name: "bad user", tokens: []string{"--dj", "@someone"}, wantErr: "--dj must be a user mention"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseArgs(cmd, tt.tokens)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseArgs(%q) error = %v, want %q", tt.tokens, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs(%q) error = %v", tt.tokens, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArgs(%q) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsRequired(t *testing.T) {
+	cmd := &Command{Args: []Arg{
+		{Name: "lang"},
+		{Name: "text", Rest: true},
+	}}
+	tests := []struct {
+		tokens  []string
+		want    parsedArgs
+		wantErr string
+	}{
+		{[]string{"en", "hello", "there"}, parsedArgs{"lang": "en", "text": "hello there"}, ""},
+		{[]string{"en"}, nil, "text is missing"},
+		{[]string{"en", ""}, parsedArgs{"lang": "en", "text": ""}, ""},
+		{nil, nil, "lang is missing"},
+	}
+	for _, tt := range tests {
+		got, _, err := parseArgs(cmd, tt.tokens)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseArgs(%q) error = %v, want %q", tt.tokens, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseArgs(%q) = %v, %v, want %v", tt.tokens, got, err, tt.want)
+		}
+	}
+}
+
+func TestArgParse(t *testing.T) {
+	tests := []struct {
+		arg     Arg
+		token   string
+		want    interface{}
+		wantErr string
+	}{
+		{Arg{Type: ArgString}, "anything", "anything", ""},
+		{Arg{Type: ArgInt}, "-4", -4, ""},
+		{Arg{Type: ArgInt}, "#12", 12, ""},
+		{Arg{Type: ArgInt}, "twelve", nil, "must be a number"},
+		{Arg{Type: ArgInt, Min: 1}, "0", nil, "must be at least 1"},
+		{Arg{Type: ArgInt, Max: 12}, "12", 12, ""},
+		{Arg{Type: ArgInt, Max: 12}, "13", nil, "must be between 0 and 12"},
+		{Arg{Type: ArgDuration}, "90", 90 * time.Second, ""},
+		{Arg{Type: ArgDuration}, "1m30s", 90 * time.Second, ""},
+		{Arg{Type: ArgDuration}, "1:30", 90 * time.Second, ""},
+		{Arg{Type: ArgDuration}, "1:02:03", time.Hour + 2*time.Minute + 3*time.Second, ""},
+		{Arg{Type: ArgDuration}, "1:75", nil, "must be a duration like 30s, 1m30s or 1:30"},
+		{Arg{Type: ArgDuration}, "0", nil, "must be longer than 0s"},
+		{Arg{Type: ArgDuration}, "-5s", nil, "must be longer than 0s"},
+		{Arg{Type: ArgDuration}, "soon", nil, "must be a duration like 30s, 1m30s or 1:30"},
+		{Arg{Type: ArgUser}, "<@123456789012345678>", "123456789012345678", ""},
+		{Arg{Type: ArgUser}, "<@!123456789012345678>", "123456789012345678", ""},
+		{Arg{Type: ArgUser}, "123456789012345678", "123456789012345678", ""},
+		{Arg{Type: ArgUser}, "<#123456789012345678>", nil, "must be a user mention"},
+		{Arg{Type: ArgChannel}, "<#123456789012345678>", "123456789012345678", ""},
+		{Arg{Type: ArgChannel}, "<@&123456789012345678>", nil, "must be a channel mention"},
+		{Arg{Type: ArgRole}, "<@&123456789012345678>", "123456789012345678", ""},
+		{Arg{Type: ArgRole}, "admins", nil, "must be a role mention"},
+		{Arg{Type: ArgURL}, "https://youtu.be/dQw4w9WgXcQ", "https://youtu.be/dQw4w9WgXcQ", ""},
+		{Arg{Type: ArgURL}, "<https://example.com/a.mp3>", "https://example.com/a.mp3", ""},
+		{Arg{Type: ArgURL}, "ftp://example.com/a.mp3", nil, "must be an http(s) link"},
+		{Arg{Type: ArgURL}, "example.com", nil, "must be an http(s) link"},
+		{Arg{Type: ArgBool}, "ON", true, ""},
+		{Arg{Type: ArgBool}, "off", false, ""},
+		{Arg{Type: ArgBool}, "maybe", nil, "must be on or off"},
+		{Arg{Choices: []string{"lang", "voice"}}, "Voice", "voice", ""},
+		{Arg{Choices: []string{"lang", "voice"}}, "limit", nil, "must be one of lang, voice"},
+	}
+	for _, tt := range tests {
+		got, err := tt.arg.parse(tt.token)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parse(%q) as %v error = %v, want %q", tt.token, tt.arg.Type, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parse(%q) as %v = %v, %v, want %v", tt.token, tt.arg.Type, got, err, tt.want)
+		}
+	}
+}
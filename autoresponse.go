@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// autoResponse answers chat messages matching Pattern. Trigger is one of
+// exact, contains, word or regex, Response one of text, reaction, sound or
+// image with Value the text, emoji, clip file or image link.
+type autoResponse struct {
+	ID       int      `json:"id"`
+	Trigger  string   `json:"trigger"`
+	Pattern  string   `json:"pattern"`
+	Response string   `json:"response"`
+	Value    string   `json:"value"`
+	Chance   int      `json:"chance"`   // percent
+	Cooldown int      `json:"cooldown"` // seconds
+	Channels []string `json:"channels"` // empty means everywhere
+
+	compiled *regexp.Regexp
+}
+
+var (
+	autoResponseTriggers  = []string{"exact", "contains", "word", "regex"}
+	autoResponseResponses = []string{"text", "reaction", "sound", "image"}
+
+	// Guilds that never changed their rules get these, Pattern and Value
+	// are message keys.
+	defaultAutoResponses = []autoResponse{
+		{ID: 1, Trigger: "exact", Pattern: "autoresponse.default_pattern", Response: "text", Value: "autoresponse.default_value", Chance: 100},
+	}
+
+	guildAutoResponses = map[string][]*autoResponse{}
+	autoResponseMutex  sync.Mutex
+
+	// When each rule last fired, for its cooldown.
+	autoResponseFired = map[string]time.Time{}
+)
+
+var maxAutoResponses = 50
+
+// guildAutoResponseRules returns the guild's rules, the defaults for
+// guilds without their own. Call it with autoResponseMutex held.
+func guildAutoResponseRules(guild string) []*autoResponse {
+	if rules, ok := guildAutoResponses[guild]; ok {
+		return rules
+	}
+	var rules []*autoResponse
+	for _, rule := range defaultAutoResponses {
+		copied := rule
+		copied.Pattern, copied.Value = tr(guild, rule.Pattern), tr(guild, rule.Value)
+		rules = append(rules, &copied)
+	}
+	return rules
+}
+
+// compile builds the matcher of rule. Letters and digits next to a word
+// trigger mean it's part of a longer word, \b would only know ASCII.
+func (rule *autoResponse) compile() error {
+	if rule.compiled != nil || (rule.Trigger != "regex" && rule.Trigger != "word") {
+		return nil
+	}
+	expr := "(?i)" + rule.Pattern
+	if rule.Trigger == "word" {
+		expr = `(?i)(?:^|[^\pL\pN_])` + regexp.QuoteMeta(rule.Pattern) + `(?:$|[^\pL\pN_])`
+	}
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	rule.compiled = compiled
+	return nil
+}
+
+func (rule *autoResponse) matches(content string) bool {
+	switch rule.Trigger {
+	case "exact":
+		return strings.EqualFold(strings.TrimSpace(content), rule.Pattern)
+	case "contains":
+		return strings.Contains(strings.ToLower(content), strings.ToLower(rule.Pattern))
+	}
+	if err := rule.compile(); err != nil {
+		return false
+	}
+	return rule.compiled.MatchString(content)
+}
+
+func (rule *autoResponse) inScope(channel string) bool {
+	if len(rule.Channels) == 0 {
+		return true
+	}
+	for _, scoped := range rule.Channels {
+		if scoped == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// autoRespond fires the first of the guild's rules that matches m and is
+// in scope, off cooldown and lucky.
+func autoRespond(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !config.AutoResponses || m.Author.Bot || m.Content == "" {
+		return
+	}
+	now := time.Now()
+	var fired *autoResponse
+
+	autoResponseMutex.Lock()
+	for _, rule := range guildAutoResponseRules(m.GuildID) {
+		if !rule.inScope(m.ChannelID) || !rule.matches(m.Content) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", m.GuildID, rule.ID)
+		if now.Sub(autoResponseFired[key]) < time.Duration(rule.Cooldown)*time.Second {
+			continue
+		}
+		if rule.Chance < 100 && rand.Intn(100) >= rule.Chance {
+			continue
+		}
+		autoResponseFired[key] = now
+		copied := *rule
+		fired = &copied
+		break
+	}
+	autoResponseMutex.Unlock()
+
+	if fired != nil {
+		sendAutoResponse(s, m, fired)
+	}
+}
+
+func sendAutoResponse(s *discordgo.Session, m *discordgo.MessageCreate, rule *autoResponse) {
+	var err error
+	switch rule.Response {
+	case "text":
+		_, err = sendMessage(s, m.ChannelID, rule.Value)
+	case "image":
+		if err = waitToSend(m.ChannelID); err == nil {
+			_, err = s.ChannelMessageSendEmbed(m.ChannelID, Embed{}.Image(rule.Value).Build(m.GuildID))
+		}
+	case "reaction":
+		err = addReaction(s, m.ChannelID, m.ID, rule.Value)
+	case "sound":
+		// Only where the bot already is, it doesn't join voice for a joke.
+		voice, _ := findVoiceConnection(m.GuildID, "")
+		if voice.Mixer == nil {
+			return
+		}
+		var src *ffmpegSource
+		src, err = newFFmpegSource(rule.Value, ffmpegOptions{Gain: normalizationGain(rule.Value)})
+		if err == nil {
+			voice.Mixer.Overlay(src)
+		}
+	}
+	if err != nil {
+		log.Println("Error sending auto response", rule.ID, "in guild", m.GuildID, err)
+	}
+}
+
+// emojiPattern takes custom emojis as typed in chat, <:name:id>, which
+// reactions want as name:id.
+var emojiPattern = regexp.MustCompile(`^<a?:(\w+:\d+)>$`)
+
+func addAutoResponse(ctx *Context) {
+	rule := &autoResponse{
+		Trigger:  ctx.String("trigger"),
+		Pattern:  ctx.String("pattern"),
+		Response: ctx.String("response"),
+		Value:    ctx.String("value"),
+		Chance:   100,
+	}
+	if ctx.Has("chance") {
+		rule.Chance = ctx.Int("chance")
+	}
+	if ctx.Has("cooldown") {
+		rule.Cooldown = int(ctx.Duration("cooldown") / time.Second)
+	}
+	if ctx.Has("channel") {
+		rule.Channels = []string{ctx.String("channel")}
+	}
+	if rule.Value == "" && rule.Response == "image" && len(ctx.Attachments) > 0 {
+		rule.Value = ctx.Attachments[0]
+	}
+	if err := checkAutoResponse(rule); err != nil {
+		ctx.UsageError(err)
+		return
+	}
+
+	autoResponseMutex.Lock()
+	rules := guildAutoResponseRules(ctx.GuildID)
+	full := len(rules) >= maxAutoResponses
+	var err error
+	if !full {
+		for _, existing := range rules {
+			if existing.ID >= rule.ID {
+				rule.ID = existing.ID + 1
+			}
+		}
+		if rule.ID == 0 {
+			rule.ID = 1
+		}
+		guildAutoResponses[ctx.GuildID] = append(rules, rule)
+		err = saveData("autoresponses", guildAutoResponses)
+	}
+	autoResponseMutex.Unlock()
+
+	switch {
+	case full:
+		ctx.Say("autoresponse.full", maxAutoResponses)
+	case err != nil:
+		log.Println("Error saving auto responses,", err)
+		ctx.Say("autoresponse.save_failed")
+	default:
+		ctx.Say("autoresponse.added", rule.ID)
+	}
+}
+
+// checkAutoResponse validates a new rule, fixing up what can be fixed.
+func checkAutoResponse(rule *autoResponse) error {
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return &ArgError{Arg: "pattern", Err: localErr("args.empty")}
+	}
+	if err := rule.compile(); err != nil {
+		return &ArgError{Arg: "pattern", Err: localErr("args.regex")}
+	}
+	if strings.TrimSpace(rule.Value) == "" {
+		return &ArgError{Arg: "value", Err: localErr("args.missing")}
+	}
+	switch rule.Response {
+	case "reaction":
+		if match := emojiPattern.FindStringSubmatch(rule.Value); match != nil {
+			rule.Value = match[1]
+		}
+	case "image":
+		link, err := (&Arg{Type: ArgURL}).parse(rule.Value)
+		if err != nil {
+			return &ArgError{Arg: "value", Err: err}
+		}
+		rule.Value = link.(string)
+	case "sound":
+		// Kept as the file, library ids move when tracks are added.
+		path, err := soundResponsePath(rule.Value)
+		if err != nil {
+			return &ArgError{Arg: "value", Err: localErr("args.unknown_clip")}
+		}
+		rule.Value = path
+	}
+	return nil
+}
+
+// soundResponsePath finds the file a sound rule's clip, a library id or
+// name, plays.
+func soundResponsePath(clip string) (string, error) {
+	tracks, err := resolveScheduleTarget(clip)
+	if err != nil {
+		return "", err
+	}
+	return tracks[0].Path, nil
+}
+
+// migrateSoundResponses turns sound rules saved with a library id or name
+// into the file they played then.
+func migrateSoundResponses() error {
+	autoResponseMutex.Lock()
+	defer autoResponseMutex.Unlock()
+	changed := false
+	for guild, rules := range guildAutoResponses {
+		for _, rule := range rules {
+			if rule.Response != "sound" {
+				continue
+			}
+			if _, err := os.Stat(rule.Value); err == nil {
+				continue
+			}
+			path, err := soundResponsePath(rule.Value)
+			if err != nil {
+				log.Println("Auto response", rule.ID, "in guild", guild, "plays a missing clip,", rule.Value)
+				continue
+			}
+			rule.Value = path
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveData("autoresponses", guildAutoResponses)
+}
+
+func listAutoResponses(ctx *Context) {
+	autoResponseMutex.Lock()
+	var lines []string
+	for _, rule := range guildAutoResponseRules(ctx.GuildID) {
+		line := fmt.Sprintf("#%d %s %q → %s %s", rule.ID, rule.Trigger, rule.Pattern, rule.Response, rule.Value)
+		if rule.Chance < 100 {
+			line += fmt.Sprintf(", %d%%", rule.Chance)
+		}
+		if rule.Cooldown > 0 {
+			line += ", " + ctx.T("autoresponse.every", time.Duration(rule.Cooldown)*time.Second)
+		}
+		for _, channel := range rule.Channels {
+			line += ", " + ctx.T("autoresponse.in", channel)
+		}
+		lines = append(lines, line)
+	}
+	autoResponseMutex.Unlock()
+
+	if len(lines) == 0 {
+		ctx.Say("autoresponse.none")
+		return
+	}
+	paginateLines(ctx, ctx.T("autoresponse.title"), lines)
+}
+
+func removeAutoResponse(ctx *Context) {
+	id := ctx.Int("id")
+	autoResponseMutex.Lock()
+	rules := guildAutoResponseRules(ctx.GuildID)
+	found := false
+	var kept []*autoResponse
+	for _, rule := range rules {
+		if rule.ID == id {
+			found = true
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	var err error
+	if found {
+		// An empty list is kept, so the defaults don't come back.
+		if kept == nil {
+			kept = []*autoResponse{}
+		}
+		guildAutoResponses[ctx.GuildID] = kept
+		err = saveData("autoresponses", guildAutoResponses)
+	}
+	autoResponseMutex.Unlock()
+
+	switch {
+	case !found:
+		ctx.Say("autoresponse.unknown", id)
+	case err != nil:
+		log.Println("Error saving auto responses,", err)
+		ctx.Say("autoresponse.remove_failed")
+	default:
+		ctx.Say("autoresponse.removed", id)
+	}
+}
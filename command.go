@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command is an entry of the command registry. A command with
+// Subcommands runs the one named by its first argument, Run is only called
+// when none matches and may be nil.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Description string
+	Category    string
+	Examples    []string
+	// Level is the least permission level that may run the command.
+	Level       PermLevel
+	Cooldown    Cooldown
+	Args        []Arg
+	Flags       []Arg
+	Subcommands []*Command
+	Run         func(ctx *Context)
+}
+
+// Arg describes a positional argument or a flag. A Rest argument takes
+// all remaining words as one string and must come last. Min and Max limit
+// ArgInt values and ArgDuration seconds, Choices limits any value to a set
+// of words.
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	Rest     bool
+	Min, Max int
+	Choices  []string
+	// Complete suggests values while a slash command is typed.
+	Complete completer
+}
+
+// Author is the user that invoked a command.
+type Author struct {
+	ID   string
+	Name string
+}
+
+// replier delivers answers back to wherever a command came from.
+type replier interface {
+	Reply(text string) error
+	ReplyEmbed(embed *discordgo.MessageEmbed) error
+	// ReplyMessage sends a message with files or components and returns
+	// it, for answers that are changed later like paginated lists.
+	ReplyMessage(message *discordgo.MessageSend) (*discordgo.Message, error)
+}
+
+// Context is everything a handler gets about one invocation. Session is
+// only there for Discord specific features like voice and file uploads,
+// plain answers go through Reply. Name is the command as typed, Path the
+// command and sub-command it resolved to, like "library play".
+type Context struct {
+	Command   *Command
+	Name      string
+	Path      string
+	Args      []string
+	Author    Author
+	GuildID   string
+	ChannelID string
+	Session   *discordgo.Session
+	// Attachments are the URLs of files sent along with the command.
+	Attachments []string
+
+	values  parsedArgs
+	replier replier
+	parse   func() (parsedArgs, []string, error)
+	// status is how the invocation ended, for logs and metrics.
+	status string
+}
+
+// Has reports whether the argument or flag called name was given.
+func (ctx *Context) Has(name string) bool {
+	_, ok := ctx.values[name]
+	return ok
+}
+
+// String returns string, mention, URL and choice arguments, "" when the
+// argument wasn't given.
+func (ctx *Context) String(name string) string {
+	s, _ := ctx.values[name].(string)
+	return s
+}
+
+func (ctx *Context) Int(name string) int {
+	n, _ := ctx.values[name].(int)
+	return n
+}
+
+func (ctx *Context) Duration(name string) time.Duration {
+	d, _ := ctx.values[name].(time.Duration)
+	return d
+}
+
+func (ctx *Context) Bool(name string) bool {
+	b, _ := ctx.values[name].(bool)
+	return b
+}
+
+func (ctx *Context) Reply(text string) {
+	err := ctx.replier.Reply(text)
+	if err != nil {
+		log.Println("Error replying,", err)
+	}
+}
+
+func (ctx *Context) Replyf(format string, a ...interface{}) {
+	ctx.Reply(fmt.Sprintf(format, a...))
+}
+
+// ReplyEmbed answers with embed in the guild's theme.
+func (ctx *Context) ReplyEmbed(embed Embed) {
+	err := ctx.replier.ReplyEmbed(embed.Build(ctx.GuildID))
+	if err != nil {
+		log.Println("Error replying,", err)
+	}
+}
+
+// ReplyMessage answers with a message that can have files and components,
+// it returns nil when that failed.
+func (ctx *Context) ReplyMessage(message *discordgo.MessageSend) *discordgo.Message {
+	sent, err := ctx.replier.ReplyMessage(message)
+	if err != nil {
+		log.Println("Error replying,", err)
+	}
+	return sent
+}
+
+// UsageError tells the author what was wrong with the arguments and how
+// the command is meant to be called.
+func (ctx *Context) UsageError(err error) {
+	ctx.status = statusUsage
+	ctx.Say("usage", ctx.Explain(err), ctx.Command.Usage)
+}
+
+// channelReplier answers in a Discord text channel.
+type channelReplier struct {
+	session   *discordgo.Session
+	channelID string
+}
+
+func (r channelReplier) Reply(text string) error {
+	err := waitToSend(r.channelID)
+	if err != nil {
+		return err
+	}
+	_, err = r.session.ChannelMessageSend(r.channelID, text)
+	return err
+}
+
+func (r channelReplier) ReplyEmbed(embed *discordgo.MessageEmbed) error {
+	err := waitToSend(r.channelID)
+	if err != nil {
+		return err
+	}
+	_, err = r.session.ChannelMessageSendEmbed(r.channelID, embed)
+	return err
+}
+
+func (r channelReplier) ReplyMessage(message *discordgo.MessageSend) (*discordgo.Message, error) {
+	err := waitToSend(r.channelID)
+	if err != nil {
+		return nil, err
+	}
+	return r.session.ChannelMessageSendComplex(r.channelID, message)
+}
+
+var (
+	commands    = map[string]*Command{}
+	commandList []*Command
+)
+
+// registerCommand adds cmd to the registry under its name and aliases.
+func registerCommand(cmd *Command) {
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if _, ok := commands[name]; ok {
+			log.Fatalln("Command registered twice:", name)
+		}
+		commands[name] = cmd
+	}
+	commandList = append(commandList, cmd)
+}
+
+func (cmd *Command) subcommand(name string) *Command {
+	for _, sub := range cmd.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+		for _, alias := range sub.Aliases {
+			if alias == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// runCommand parses line, the message without the prefix, and runs the
+// command it names. It returns false for unknown commands.
+func runCommand(ctx *Context, line string) bool {
+	tokens, starts, err := tokenizeWithOffsets(line)
+	if err != nil {
+		ctx.Say("error", ctx.Explain(err))
+		return true
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	name, args := tokens[0], tokens[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		return false
+	}
+	path := []string{cmd.Name}
+	for len(args) > 0 {
+		sub := cmd.subcommand(args[0])
+		if sub == nil {
+			break
+		}
+		path = append(path, sub.Name)
+		cmd, args = sub, args[1:]
+	}
+	ctx.Name = name
+	offset := len(tokens) - len(args)
+	return dispatch(ctx, cmd, path, func() (parsedArgs, []string, error) {
+		return parseTokens(cmd, args, func(i int) string {
+			return strings.TrimSpace(line[starts[offset+i]:])
+		})
+	})
+}
+
+// dispatch runs cmd through the middleware chain, see middleware.go.
+// Every way of invoking a command ends up here, path is the command and
+// sub-command names.
+func dispatch(ctx *Context, cmd *Command, path []string, parse func() (parsedArgs, []string, error)) bool {
+	ctx.Command, ctx.Path, ctx.parse = cmd, strings.Join(path, " "), parse
+	if cmd.Run == nil {
+		var names []string
+		for _, sub := range cmd.Subcommands {
+			names = append(names, sub.Name)
+		}
+		ctx.Say("command.pick_subcommand", strings.Join(names, ", "))
+		return true
+	}
+	handler := cmd.Run
+	for i := len(commandMiddleware) - 1; i >= 0; i-- {
+		handler = commandMiddleware[i](handler)
+	}
+	handler(ctx)
+	return true
+}
+
+// messageContext builds the Context for a command sent as a chat message.
+func messageContext(s *discordgo.Session, m *discordgo.MessageCreate) *Context {
+	var attachments []string
+	for _, attachment := range m.Attachments {
+		attachments = append(attachments, attachment.URL)
+	}
+	return &Context{
+		Author:      Author{ID: m.Author.ID, Name: m.Author.Username},
+		GuildID:     m.GuildID,
+		ChannelID:   m.ChannelID,
+		Session:     s,
+		Attachments: attachments,
+		replier:     channelReplier{session: s, channelID: m.ChannelID},
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is how the bot is run. Each layer overrides the one before it:
+// the defaults, a YAML or TOML file, DMASIK_* environment variables and
+// command line flags. What guilds change with commands is data, not
+// config.
+type Config struct {
+	Token string
+
+	Prefixes []string
+	Language string
+	Persona  string
+
+	DataPath       string
+	LibraryPath    string
+	SoundboardPath string
+	LocalesPath    string
+	BruhSound      string
+	StalMusic      string
+
+	Recording     bool
+	TTS           bool
+	AutoResponses bool
+	Suggestions   bool
+
+	// TTSEngine is espeak or piper, piper reads voices from PiperModels.
+	TTSEngine   string
+	PiperModels string
+
+	MaxTags          int
+	MaxAutoResponses int
+	MaxPrefixes      int
+	SongHistory      int
+	TTSLimit         int
+
+	// File is the config file that was read, if any.
+	File string
+	// Args is what's left of the command line after the flags, like
+	// "config check".
+	Args []string
+	// sources says where each setting got its value, by key.
+	sources map[string]string
+}
+
+// config is the running bot's config. Until main loads it, it's the
+// defaults.
+var config = defaultConfig()
+
+// Config files are looked for under these names when none is given.
+var configFileNames = []string{"dmasik.yaml", "dmasik.yml", "dmasik.toml"}
+
+func defaultConfig() *Config {
+	return &Config{
+		Prefixes: []string{"."},
+		Language: "en",
+		Persona:  "uwu",
+
+		DataPath:       "./data",
+		LibraryPath:    "./audio",
+		SoundboardPath: "./audio",
+		LocalesPath:    "./locales",
+		BruhSound:      "./audio/bruh.opus",
+		StalMusic:      "./audio/stal.opus",
+
+		Recording:     true,
+		TTS:           true,
+		AutoResponses: true,
+		Suggestions:   true,
+
+		TTSEngine:   "espeak",
+		PiperModels: "./voices",
+
+		MaxTags:          200,
+		MaxAutoResponses: 50,
+		MaxPrefixes:      5,
+		SongHistory:      50,
+		TTSLimit:         200,
+
+		sources: map[string]string{},
+	}
+}
+
+// setting is one config key and the field it fills.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	// legacyEnv is read before the DMASIK_ variable, for setups older
+	// than config files.
+	legacyEnv string
+	// value is a *string, *[]string, *bool or *int.
+	value interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "discord.token", usage: "Discord bot token", secret: true, legacyEnv: "DISCORD_TOKEN", value: &c.Token},
+		{key: "commands.prefixes", usage: "default command prefixes, comma separated", value: &c.Prefixes},
+		{key: "locale.language", usage: "default reply language", value: &c.Language},
+		{key: "locale.persona", usage: "default reply persona", value: &c.Persona},
+		{key: "storage.path", usage: "directory guild data is saved in", value: &c.DataPath},
+		{key: "paths.library", usage: "music library directory", value: &c.LibraryPath},
+		{key: "paths.soundboard", usage: "directory clips are saved to", value: &c.SoundboardPath},
+		{key: "paths.locales", usage: "message catalogs directory", value: &c.LocalesPath},
+		{key: "paths.bruh", usage: "sound .bruh plays", value: &c.BruhSound},
+		{key: "paths.stal", usage: "music .stal plays", value: &c.StalMusic},
+		{key: "features.recording", usage: "allow guilds to turn on voice recording", value: &c.Recording},
+		{key: "features.tts", usage: "text to speech", value: &c.TTS},
+		{key: "features.autoresponses", usage: "auto responses", value: &c.AutoResponses},
+		{key: "features.suggestions", usage: "reply to unknown commands", value: &c.Suggestions},
+		{key: "tts.engine", usage: "speech synthesizer, espeak or piper", value: &c.TTSEngine},
+		{key: "tts.piper_models", usage: "directory of piper voice models", value: &c.PiperModels},
+		{key: "limits.tags", usage: "tags per guild", value: &c.MaxTags},
+		{key: "limits.autoresponses", usage: "auto responses per guild", value: &c.MaxAutoResponses},
+		{key: "limits.prefixes", usage: "prefixes per guild", value: &c.MaxPrefixes},
+		{key: "limits.history", usage: "songs kept in a guild's history", value: &c.SongHistory},
+		{key: "limits.tts", usage: "default TTS length limit", value: &c.TTSLimit},
+	}
+}
+
+// envName is the environment variable for key, DMASIK_LIMITS_TAGS for
+// limits.tags.
+func envName(key string) string {
+	return "DMASIK_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// set parses raw, text or a list, into the setting.
+func (s setting) set(raw interface{}) error {
+	text, isText := raw.(string)
+	switch v := s.value.(type) {
+	case *[]string:
+		if list, ok := raw.([]string); ok {
+			*v = append([]string(nil), list...)
+			return nil
+		}
+		*v = nil
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+		return nil
+	}
+	if !isText {
+		return errors.New("wants a single value, not a list")
+	}
+	switch v := s.value.(type) {
+	case *string:
+		*v = text
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return fmt.Errorf("wants true or false, not %q", text)
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return fmt.Errorf("wants a whole number, not %q", text)
+		}
+		*v = n
+	}
+	return nil
+}
+
+// String shows the setting's value, with secrets masked.
+func (s setting) String() string {
+	switch v := s.value.(type) {
+	case *string:
+		if s.secret && *v != "" {
+			return "(set)"
+		}
+		return *v
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	}
+	return ""
+}
+
+// flagValue keeps what a flag was given, so flags can be parsed before
+// the file and environment are read and still override them.
+type flagValue struct {
+	setting setting
+	given   map[string]string
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(text string) error {
+	f.given[f.setting.key] = text
+	return nil
+}
+
+// IsBoolFlag lets toggles be given as --features.tts alone.
+func (f *flagValue) IsBoolFlag() bool {
+	_, ok := f.setting.value.(*bool)
+	return ok
+}
+
+// ConfigError lists everything wrong with a config, so it can all be fixed
+// in one go.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	if len(e) == 1 {
+		return "config: " + e[0]
+	}
+	return fmt.Sprintf("config has %d problems:\n  %s", len(e), strings.Join(e, "\n  "))
+}
+
+// loadConfig builds the config from args, the command line without the
+// program name, and the environment as lookupEnv sees it. Flags can come
+// before, between or after the other arguments, which end up in Args. A
+// config with problems comes back along with a ConfigError listing them.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := defaultConfig()
+	settings := c.settings()
+
+	flags := flag.NewFlagSet("dmasik", flag.ContinueOnError)
+	file := flags.String("config", "", "config file, .yaml, .yml or .toml")
+	given := map[string]string{}
+	for _, s := range settings {
+		flags.Var(&flagValue{setting: s, given: given}, s.key, s.usage)
+	}
+	// The flag package stops at the first argument that isn't a flag,
+	// pick those out one at a time and carry on with the rest.
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		c.Args = append(c.Args, args[0])
+		args = args[1:]
+	}
+
+	var problems ConfigError
+	setFrom := func(s setting, raw interface{}, source string) {
+		if err := s.set(raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s from %s %v", s.key, source, err))
+			return
+		}
+		c.sources[s.key] = source
+	}
+
+	c.File = *file
+	if c.File == "" {
+		c.File, _ = lookupEnv("DMASIK_CONFIG")
+	}
+	if c.File == "" {
+		c.File = findConfigFile()
+	}
+	if c.File != "" {
+		values, err := readConfigFile(c.File)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, s := range settings {
+			if raw, ok := values[s.key]; ok {
+				setFrom(s, raw, c.File)
+				delete(values, s.key)
+			}
+		}
+		for _, key := range sortedKeys(values) {
+			problems = append(problems, unknownSetting(key, c.File, settings))
+		}
+	}
+
+	for _, s := range settings {
+		for _, name := range []string{s.legacyEnv, envName(s.key)} {
+			if name == "" {
+				continue
+			}
+			if text, ok := lookupEnv(name); ok {
+				setFrom(s, text, name)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if text, ok := given[s.key]; ok {
+			setFrom(s, text, "--"+s.key)
+		}
+	}
+
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return c, problems
+	}
+	return c, nil
+}
+
+// findConfigFile returns the first of configFileNames that exists.
+func findConfigFile() string {
+	for _, name := range configFileNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// readConfigFile reads a YAML or TOML file into values by dotted key.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		values, err := parseTOML(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return values, nil
+	case ".yaml", ".yml":
+		var tree map[string]interface{}
+		if err := yaml.Unmarshal(raw, &tree); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		values := map[string]interface{}{}
+		if err := flattenYAML("", tree, values); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%s: config files have to be .yaml, .yml or .toml", path)
+}
+
+// flattenYAML puts the values in tree into values under dotted keys.
+func flattenYAML(prefix string, tree map[string]interface{}, values map[string]interface{}) error {
+	for name, value := range tree {
+		key := prefix + name
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flattenYAML(key+".", v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			list := []string{}
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]interface{}, []interface{}:
+					return fmt.Errorf("%s can only list plain values", key)
+				}
+				list = append(list, fmt.Sprint(item))
+			}
+			values[key] = list
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// unknownSetting describes a key no setting has, with the closest one
+// when it looks like a typo.
+func unknownSetting(key string, source string, settings []setting) string {
+	best, bestDistance := "", utf8.RuneCountInString(key)/3+2
+	for _, s := range settings {
+		if distance := typoDistance(key, s.key); distance < bestDistance {
+			best, bestDistance = s.key, distance
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("%s in %s isn't a setting, did you mean %s?", key, source, best)
+	}
+	return fmt.Sprintf("%s in %s isn't a setting", key, source)
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validate returns what's wrong with the config, naming where each bad
+// value came from.
+func (c *Config) validate() []string {
+	var problems []string
+	bad := func(key string, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s%s %s", key, c.from(key), fmt.Sprintf(format, a...)))
+	}
+
+	if strings.TrimSpace(c.Token) == "" {
+		bad("discord.token", "is missing, set it in the config file, DMASIK_DISCORD_TOKEN, DISCORD_TOKEN or --discord.token")
+	}
+
+	if len(c.Prefixes) == 0 {
+		bad("commands.prefixes", "needs at least one prefix")
+	}
+	for _, prefix := range c.Prefixes {
+		switch {
+		case utf8.RuneCountInString(prefix) > maxPrefixLength:
+			bad("commands.prefixes", "has %q, prefixes can be at most %d characters", prefix, maxPrefixLength)
+		case strings.IndexFunc(prefix, unicode.IsSpace) >= 0:
+			bad("commands.prefixes", "has %q, prefixes can't have spaces", prefix)
+		case strings.HasPrefix(prefix, "<"):
+			bad("commands.prefixes", "has %q, prefixes can't start with <, mentions do", prefix)
+		}
+	}
+
+	for _, limit := range []struct {
+		key   string
+		value int
+		max   int
+	}{
+		{"limits.tags", c.MaxTags, 0},
+		{"limits.autoresponses", c.MaxAutoResponses, 0},
+		{"limits.prefixes", c.MaxPrefixes, 0},
+		{"limits.history", c.SongHistory, 0},
+		{"limits.tts", c.TTSLimit, ttsMaxLimit},
+	} {
+		if limit.value < 1 {
+			bad(limit.key, "is %d, it has to be at least 1", limit.value)
+		} else if limit.max > 0 && limit.value > limit.max {
+			bad(limit.key, "is %d, it can be at most %d", limit.value, limit.max)
+		}
+	}
+	switch c.TTSEngine {
+	case "espeak":
+	case "piper":
+		if info, err := os.Stat(c.PiperModels); err != nil || !info.IsDir() {
+			bad("tts.piper_models", "is %s, which isn't a directory piper can load voices from", c.PiperModels)
+		}
+	default:
+		bad("tts.engine", "is %q, it can be espeak or piper", c.TTSEngine)
+	}
+
+	if c.MaxPrefixes >= 1 && len(c.Prefixes) > c.MaxPrefixes {
+		bad("commands.prefixes", "has %d prefixes, more than limits.prefixes allows (%d)", len(c.Prefixes), c.MaxPrefixes)
+	}
+
+	// Directories that don't exist yet are fine, they're created or
+	// scanned as empty, but a file in their place isn't.
+	for _, dir := range []struct{ key, path string }{
+		{"storage.path", c.DataPath},
+		{"paths.library", c.LibraryPath},
+		{"paths.soundboard", c.SoundboardPath},
+	} {
+		if info, err := os.Stat(dir.path); err == nil && !info.IsDir() {
+			bad(dir.key, "is %s, which is a file, not a directory", dir.path)
+		}
+	}
+
+	found, err := readCatalogs(c.LocalesPath)
+	if err != nil {
+		bad("paths.locales", "can't be read: %v", err)
+		return problems
+	}
+	var offered []string
+	for language := range found {
+		offered = append(offered, language)
+	}
+	sort.Strings(offered)
+	if _, ok := found[c.Language]; !ok {
+		bad("locale.language", "is %q, the catalogs have %s", c.Language, strings.Join(offered, ", "))
+		return problems
+	}
+	offered = offered[:0]
+	for persona := range found[c.Language] {
+		offered = append(offered, persona)
+	}
+	sort.Strings(offered)
+	if _, ok := found[c.Language][c.Persona]; !ok {
+		bad("locale.persona", "is %q, the %s catalogs have %s", c.Persona, c.Language, strings.Join(offered, ", "))
+	}
+	return problems
+}
+
+// from names where key's value came from, for messages about it.
+func (c *Config) from(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return " (from " + source + ")"
+	}
+	return ""
+}
+
+// source is where key's value came from, "default" when nothing set it.
+func (c *Config) source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return "default"
+}
+
+// apply makes c the running config.
+func (c *Config) apply() {
+	config = c
+	defaultPrefixes = c.Prefixes
+	defaultLanguage = c.Language
+	defaultPersona = c.Persona
+
+	dataPath = c.DataPath
+	libraryPath = c.LibraryPath
+	soundboardPath = c.SoundboardPath
+	localesPath = c.LocalesPath
+	bruhSoundPath = c.BruhSound
+	stalMusicPath = c.StalMusic
+
+	maxTagsPerGuild = c.MaxTags
+	maxAutoResponses = c.MaxAutoResponses
+	maxPrefixes = c.MaxPrefixes
+	maxSongHistory = c.SongHistory
+	ttsDefaultLimit = c.TTSLimit
+	tts = c.ttsEngine()
+}
+
+// ttsEngine is the speech synthesizer the config picks.
+func (c *Config) ttsEngine() ttsEngine {
+	if c.TTSEngine == "piper" {
+		return piperEngine{Binary: "piper", ModelDir: c.PiperModels}
+	}
+	return espeakEngine{Binary: "espeak-ng"}
+}
+
+// checkConfig is "dmasik config check": given the config as loadConfig
+// returned it, it prints every setting with where it came from and the
+// problems, if any. It returns the exit code.
+func checkConfig(c *Config, err error, out io.Writer) int {
+	if c.File != "" {
+		fmt.Fprintf(out, "Config file: %s\n\n", c.File)
+	} else {
+		fmt.Fprintf(out, "No config file, looked for %s\n\n", strings.Join(configFileNames, ", "))
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, s := range c.settings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.key, s.String(), c.source(s.key))
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, "Config is OK")
+	return 0
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// env is a fake environment for loadConfig.
+type env map[string]string
+
+func (e env) lookup(name string) (string, bool) {
+	value, ok := e[name]
+	return value, ok
+}
+
+func writeConfigFile(t *testing.T, name string, text string) string {
+	dir, err := ioutil.TempDir("", "dmasik-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path := writeConfigFile(t, "dmasik.toml", `
+# Everything the file sets
+features.tts = false
+
+[discord]
+token = "from-file"
+
+[commands]
+prefixes = ["!", 'd!']  # two of them
+
+[limits]
+tags = 10
+history = 20
+tts = 300
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c, err := loadConfig(
+		[]string{"--config", path, "--limits.history", "30", "--features.recording=false"},
+		env{"DISCORD_TOKEN": "legacy", "DMASIK_LIMITS_TAGS": "15", "DMASIK_LIMITS_HISTORY": "25"}.lookup,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Token != "legacy" || c.source("discord.token") != "DISCORD_TOKEN" {
+		t.Errorf("token = %q from %s, want the environment's", c.Token, c.source("discord.token"))
+	}
+	if want := []string{"!", "d!"}; !reflect.DeepEqual(c.Prefixes, want) {
+		t.Errorf("prefixes = %q, want %q", c.Prefixes, want)
+	}
+	if c.MaxTags != 15 || c.source("limits.tags") != "DMASIK_LIMITS_TAGS" {
+		t.Errorf("limits.tags = %d from %s, want 15 from the environment", c.MaxTags, c.source("limits.tags"))
+	}
+	if c.SongHistory != 30 || c.source("limits.history") != "--limits.history" {
+		t.Errorf("limits.history = %d from %s, want 30 from the flag", c.SongHistory, c.source("limits.history"))
+	}
+	if c.TTSLimit != 300 || c.source("limits.tts") != path {
+		t.Errorf("limits.tts = %d from %s, want 300 from the file", c.TTSLimit, c.source("limits.tts"))
+	}
+	if c.TTS || c.Recording || !c.AutoResponses {
+		t.Errorf("features = tts %v, recording %v, auto responses %v, want only auto responses", c.TTS, c.Recording, c.AutoResponses)
+	}
+	if c.MaxAutoResponses != 50 || c.source("limits.autoresponses") != "default" {
+		t.Errorf("limits.autoresponses = %d from %s, want the default", c.MaxAutoResponses, c.source("limits.autoresponses"))
+	}
+
+	c, err = loadConfig(nil, env{"DISCORD_TOKEN": "legacy", "DMASIK_DISCORD_TOKEN": "new"}.lookup)
+	if err != nil || c.Token != "new" {
+		t.Errorf("token = %q, %v, want DMASIK_DISCORD_TOKEN over DISCORD_TOKEN", c.Token, err)
+	}
+}
+
+func TestLoadConfigArgs(t *testing.T) {
+	c, err := loadConfig([]string{"config", "--limits.tags", "5", "check", "--features.tts=false"}, env{"DISCORD_TOKEN": "x"}.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"config", "check"}; !reflect.DeepEqual(c.Args, want) {
+		t.Errorf("args = %q, want %q", c.Args, want)
+	}
+	if c.MaxTags != 5 || c.TTS {
+		t.Errorf("limits.tags = %d, features.tts = %v, want the flags around the arguments", c.MaxTags, c.TTS)
+	}
+
+	// A file that can't be read is one more problem to show, not the end.
+	c, err = loadConfig([]string{"--config", "missing.yaml", "config", "check"}, env{}.lookup)
+	if _, ok := err.(ConfigError); !ok || c == nil || len(c.Args) != 2 {
+		t.Errorf("loadConfig = %v, %v, want the config and a ConfigError", c, err)
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfigFile(t, "bot.yml", `
+discord:
+  token: abc
+commands:
+  prefixes: [".", "?"]
+locale:
+  language: ru
+  persona: neutral
+limits:
+  prefixes: 3
+tts:
+  engine: piper
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	models := filepath.Dir(path)
+	c, err := loadConfig(nil, env{"DMASIK_CONFIG": path, "DMASIK_TTS_PIPER_MODELS": models}.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "abc" || c.Language != "ru" || c.Persona != "neutral" || c.MaxPrefixes != 3 ||
+		!reflect.DeepEqual(c.Prefixes, []string{".", "?"}) {
+		t.Errorf("config = %+v, want the file's values", c)
+	}
+	if engine := c.ttsEngine(); engine != (piperEngine{Binary: "piper", ModelDir: models}) {
+		t.Errorf("tts engine = %+v, want piper with the models in %s", engine, models)
+	}
+
+	_, err = loadConfig(nil, env{"DMASIK_CONFIG": path, "DMASIK_TTS_PIPER_MODELS": filepath.Join(models, "missing")}.lookup)
+	if err == nil || !strings.Contains(err.Error(), "tts.piper_models") {
+		t.Errorf("err = %v, want the missing models directory", err)
+	}
+}
+
+func TestConfigProblems(t *testing.T) {
+	path := writeConfigFile(t, "dmasik.yaml", `
+commands:
+  prefixes: ["<", "a b"]
+limits:
+  tag: 5
+  tts: 5000
+features:
+  tts: maybe
+locale:
+  language: xx
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := loadConfig([]string{"--config=" + path, "--limits.history", "ten"}, env{"DMASIK_LIMITS_PREFIXES": "0"}.lookup)
+	problems, ok := err.(ConfigError)
+	if !ok {
+		t.Fatalf("err = %v, want a ConfigError", err)
+	}
+	for _, want := range []string{
+		"limits.tag in " + path + " isn't a setting, did you mean limits.tags?",
+		"features.tts from " + path + " wants true or false, not \"maybe\"",
+		"limits.history from --limits.history wants a whole number, not \"ten\"",
+		"discord.token is missing",
+		`commands.prefixes (from ` + path + `) has "<", prefixes can't start with <`,
+		`commands.prefixes (from ` + path + `) has "a b", prefixes can't have spaces`,
+		"limits.tts (from " + path + ") is 5000, it can be at most 1000",
+		"limits.prefixes (from DMASIK_LIMITS_PREFIXES) is 0, it has to be at least 1",
+		`locale.language (from ` + path + `) is "xx", the catalogs have en, ru`,
+	} {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.HasPrefix(problem, want)
+		}
+		if !found {
+			t.Errorf("problems don't mention %q:\n%s", want, err)
+		}
+	}
+	if len(problems) != 9 {
+		t.Errorf("got %d problems, want 9:\n%s", len(problems), err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(`
+top = 'C:\path'   # literal strings keep backslashes
+[a.b]
+text = "tab\there # not a comment"
+list = [ "x", 'y', 3, ]
+empty = []
+n = 1_000
+yes = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"top":       `C:\path`,
+		"a.b.text":  "tab\there # not a comment",
+		"a.b.list":  []string{"x", "y", "3"},
+		"a.b.empty": []string{},
+		"a.b.n":     "1000",
+		"a.b.yes":   "true",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parseTOML = %#v, want %#v", values, want)
+	}
+
+	for text, wantErr := range map[string]string{
+		"a = 1\na = 2":       "line 2: a is set twice",
+		"[a":                 "line 1: table header is missing its ]",
+		"[[a]]":              "line 1: arrays of tables aren't supported",
+		"a = hello":          "line 1: can't read value hello, text needs quotes",
+		"a = [1, 2":          "line 1: array is missing its ], arrays have to fit on one line",
+		"a = [[1]]":          "line 1: nested arrays aren't supported",
+		"just words":         "line 1: expected key = value",
+		"bad key = 1":        `line 1: bad key "bad key"`,
+		"a = \"unterminated": `line 1: can't read string "unterminated`,
+	} {
+		if _, err := parseTOML(text); err == nil || err.Error() != wantErr {
+			t.Errorf("parseTOML(%q) error = %v, want %q", text, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CooldownScope says who shares a command's cooldown.
+type CooldownScope int
+
+const (
+	PerUser CooldownScope = iota
+	PerChannel
+	PerGuild
+)
+
+// Cooldown lets a command run Burst times in a row, after that once every
+// Per. A zero Cooldown means no limit.
+type Cooldown struct {
+	Scope CooldownScope
+	Burst int
+	Per   time.Duration
+}
+
+// describe says how often the command can be used, for .help.
+func (c Cooldown) describe(ctx *Context) string {
+	scope := ctx.T("cooldown." + map[CooldownScope]string{PerUser: "user", PerChannel: "channel", PerGuild: "server"}[c.Scope])
+	if c.Burst > 1 {
+		return ctx.T("cooldown.burst", c.Burst, c.Per, scope)
+	}
+	return ctx.T("cooldown.once", c.Per, scope)
+}
+
+// rateBucket tracks one user, channel or guild. tat is when the bucket
+// would be empty again if nothing else came in.
+type rateBucket struct {
+	tat time.Time
+}
+
+// delay is how long until limit allows another use.
+func (b *rateBucket) delay(now time.Time, limit Cooldown) time.Duration {
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	allowAt := b.tat.Add(-time.Duration(burst-1) * limit.Per)
+	if now.Before(allowAt) {
+		return allowAt.Sub(now)
+	}
+	return 0
+}
+
+// reserve books the next use and returns how long to wait for it.
+func (b *rateBucket) reserve(now time.Time, limit Cooldown) time.Duration {
+	wait := b.delay(now, limit)
+	if start := now.Add(wait); b.tat.Before(start) {
+		b.tat = start
+	}
+	b.tat = b.tat.Add(limit.Per)
+	return wait
+}
+
+type cooldownKey struct {
+	cmd *Command
+	id  string
+}
+
+// Buckets are dropped once full again, past this many entries.
+const maxCooldownBuckets = 10000
+
+var (
+	cooldownBuckets = map[cooldownKey]*rateBucket{}
+	cooldownMutex   sync.Mutex
+)
+
+// takeCooldown counts a use of cmd by ctx's author. When the cooldown
+// doesn't allow it, nothing is counted and the wait is returned.
+func takeCooldown(ctx *Context, cmd *Command, now time.Time) (time.Duration, bool) {
+	limit := cmd.Cooldown
+	if limit.Per == 0 {
+		return 0, true
+	}
+	key := cooldownKey{cmd: cmd, id: ctx.Author.ID}
+	switch limit.Scope {
+	case PerChannel:
+		key.id = ctx.ChannelID
+	case PerGuild:
+		key.id = ctx.GuildID
+	}
+
+	cooldownMutex.Lock()
+	defer cooldownMutex.Unlock()
+	if len(cooldownBuckets) > maxCooldownBuckets {
+		for k, bucket := range cooldownBuckets {
+			if bucket.tat.Before(now) {
+				delete(cooldownBuckets, k)
+			}
+		}
+	}
+	bucket, ok := cooldownBuckets[key]
+	if !ok {
+		bucket = &rateBucket{}
+		cooldownBuckets[key] = bucket
+	}
+	if wait := bucket.delay(now, limit); wait > 0 {
+		return wait, false
+	}
+	bucket.reserve(now, limit)
+	return 0, true
+}
+
+// formatWait rounds up to whole seconds, "try again in 0s" would be a lie.
+func formatWait(wait time.Duration) string {
+	return ((wait + time.Second - 1) / time.Second * time.Second).String()
+}
+
+var (
+	// Discord allows 5 messages per 5 seconds in a channel and 50
+	// requests a second overall, stay a bit below both.
+	channelSendLimit = Cooldown{Burst: 5, Per: time.Second + 100*time.Millisecond}
+	globalSendLimit  = Cooldown{Burst: 40, Per: time.Second / 40}
+
+	// Past this much waiting a message is dropped, it would only arrive
+	// long after it made sense.
+	maxSendWait = 10 * time.Second
+
+	channelSendBuckets = map[string]*rateBucket{}
+	globalSendBucket   rateBucket
+	sendMutex          sync.Mutex
+)
+
+// waitToSend blocks until a message may go to channel without hitting
+// Discord's rate limits. It returns an error instead when the channel is
+// too backed up.
+func waitToSend(channel string) error {
+	sendMutex.Lock()
+	now := time.Now()
+	bucket, ok := channelSendBuckets[channel]
+	if !ok {
+		bucket = &rateBucket{}
+		channelSendBuckets[channel] = bucket
+	}
+	if bucket.delay(now, channelSendLimit) > maxSendWait {
+		sendMutex.Unlock()
+		return fmt.Errorf("too many messages waiting for channel %s", channel)
+	}
+	wait := bucket.reserve(now, channelSendLimit)
+	if global := globalSendBucket.reserve(now, globalSendLimit); global > wait {
+		wait = global
+	}
+	sendMutex.Unlock()
+
+	if wait > 0 {
+		log.Println("Holding a message for", channel, "for", wait)
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+// sendMessage posts text to channel when the limiter lets it through,
+// for messages no command is replying with.
+func sendMessage(s *discordgo.Session, channel string, text string) (*discordgo.Message, error) {
+	if err := waitToSend(channel); err != nil {
+		return nil, err
+	}
+	return s.ChannelMessageSend(channel, text)
+}
+
+// editMessage is ChannelMessageEditComplex behind the limiter, edits
+// count against the channel like new messages do.
+func editMessage(s *discordgo.Session, edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	if err := waitToSend(edit.Channel); err != nil {
+		return nil, err
+	}
+	return s.ChannelMessageEditComplex(edit)
+}
+
+// addReaction reacts to a message behind the limiter.
+func addReaction(s *discordgo.Session, channel string, message string, emoji string) error {
+	if err := waitToSend(channel); err != nil {
+		return err
+	}
+	return s.MessageReactionAdd(channel, message, emoji)
+}
+
+// removeReaction takes user's reaction off a message behind the limiter.
+func removeReaction(s *discordgo.Session, channel string, message string, emoji string, user string) error {
+	if err := waitToSend(channel); err != nil {
+		return err
+	}
+	return s.MessageReactionRemove(channel, message, emoji, user)
+}
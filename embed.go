@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's limits for embeds, in characters.
+const (
+	embedTitleLimit       = 256
+	embedDescriptionLimit = 4096
+	embedFieldLimit       = 25
+	embedFieldNameLimit   = 256
+	embedFieldValueLimit  = 1024
+	embedFooterLimit      = 2048
+	embedAuthorLimit      = 256
+	embedTotalLimit       = 6000
+
+	defaultEmbedColor = 0x00ff00
+	maxThemeFooter    = 200
+)
+
+// Embed describes a message embed. Its methods return a changed copy and
+// leave the receiver as it was, so a half built embed can be shared and
+// finished in different ways. Build turns it into what Discord takes.
+type Embed struct {
+	title       string
+	description string
+	url         string
+	color       int
+	author      string
+	footer      string
+	image       string
+	thumbnail   string
+	timestamp   time.Time
+	fields      []embedField
+}
+
+type embedField struct {
+	name   string
+	value  string
+	inline bool
+}
+
+func newEmbed(title string) Embed {
+	return Embed{title: title}
+}
+
+func (e Embed) Title(title string) Embed {
+	e.title = title
+	return e
+}
+
+func (e Embed) Description(text string) Embed {
+	e.description = text
+	return e
+}
+
+func (e Embed) Descriptionf(format string, a ...interface{}) Embed {
+	return e.Description(fmt.Sprintf(format, a...))
+}
+
+func (e Embed) URL(link string) Embed {
+	e.url = link
+	return e
+}
+
+// Color overrides the guild's accent color.
+func (e Embed) Color(color int) Embed {
+	e.color = color
+	return e
+}
+
+func (e Embed) Author(name string) Embed {
+	e.author = name
+	return e
+}
+
+// Footer replaces the guild's footer text.
+func (e Embed) Footer(text string) Embed {
+	e.footer = text
+	return e
+}
+
+func (e Embed) Image(link string) Embed {
+	e.image = link
+	return e
+}
+
+func (e Embed) Thumbnail(link string) Embed {
+	e.thumbnail = link
+	return e
+}
+
+func (e Embed) Timestamp(t time.Time) Embed {
+	e.timestamp = t
+	return e
+}
+
+func (e Embed) Field(name string, value string) Embed {
+	return e.addField(embedField{name, value, false})
+}
+
+func (e Embed) InlineField(name string, value string) Embed {
+	return e.addField(embedField{name, value, true})
+}
+
+func (e Embed) addField(field embedField) Embed {
+	// The full slice expression makes append copy, so copies of e made
+	// before don't see the new field.
+	e.fields = append(e.fields[:len(e.fields):len(e.fields)], field)
+	return e
+}
+
+// Build makes the embed for a message in guild, with the guild's theme
+// filling in what the embed doesn't set. Whatever is over Discord's limits
+// is cut. When the whole embed is too long the description gives way
+// first, then the last fields.
+func (e Embed) Build(guild string) *discordgo.MessageEmbed {
+	theme := guildTheme(guild)
+	embed := &discordgo.MessageEmbed{
+		Title:       truncateText(e.title, embedTitleLimit),
+		Description: truncateText(e.description, embedDescriptionLimit),
+		URL:         e.url,
+		Color:       theme.Color,
+	}
+	if e.color != 0 {
+		embed.Color = e.color
+	}
+	if e.author != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: truncateText(e.author, embedAuthorLimit)}
+	}
+	footer := e.footer
+	if footer == "" {
+		footer = theme.Footer
+	}
+	if footer != "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: truncateText(footer, embedFooterLimit)}
+	}
+	if e.image != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: e.image}
+	}
+	if e.thumbnail != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: e.thumbnail}
+	}
+	if !e.timestamp.IsZero() {
+		embed.Timestamp = e.timestamp.Format(time.RFC3339)
+	}
+	for i, field := range e.fields {
+		if i == embedFieldLimit {
+			break
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   truncateText(orBlank(field.name), embedFieldNameLimit),
+			Value:  truncateText(orBlank(field.value), embedFieldValueLimit),
+			Inline: field.inline,
+		})
+	}
+
+	if over := embedLength(embed) - embedTotalLimit; over > 0 {
+		keep := utf8.RuneCountInString(embed.Description) - over
+		if keep < 1 {
+			keep = 1
+		}
+		embed.Description = truncateText(embed.Description, keep)
+	}
+	for len(embed.Fields) > 0 && embedLength(embed) > embedTotalLimit {
+		embed.Fields = embed.Fields[:len(embed.Fields)-1]
+	}
+	return embed
+}
+
+// orBlank keeps empty field names and values, which Discord refuses, as a
+// zero width space.
+func orBlank(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return "\u200b"
+	}
+	return text
+}
+
+// embedLength counts the characters Discord adds up against its total.
+func embedLength(embed *discordgo.MessageEmbed) int {
+	n := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	if embed.Author != nil {
+		n += utf8.RuneCountInString(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		n += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	for _, field := range embed.Fields {
+		n += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	return n
+}
+
+// embedTheme is how a guild's embeds look.
+type embedTheme struct {
+	Color  int    `json:"color"`
+	Footer string `json:"footer"`
+}
+
+var (
+	guildThemes = map[string]*embedTheme{}
+	themesMutex sync.Mutex
+)
+
+func guildTheme(guild string) embedTheme {
+	themesMutex.Lock()
+	defer themesMutex.Unlock()
+	if theme, ok := guildThemes[guild]; ok {
+		return *theme
+	}
+	return embedTheme{Color: defaultEmbedColor}
+}
+
+// parseColor reads colors like #ff8800, ff8800 or 0xff8800.
+func parseColor(text string) (int, error) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(text), "#"), "0x")
+	color, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return 0, &ArgError{Arg: "color", Err: localErr("args.color")}
+	}
+	return int(color), nil
+}
+
+func showTheme(ctx *Context) {
+	theme := guildTheme(ctx.GuildID)
+	footer := theme.Footer
+	if footer == "" {
+		footer = ctx.T("theme.no_footer")
+	}
+	ctx.ReplyEmbed(newEmbed(ctx.T("theme.title")).
+		InlineField(ctx.T("theme.color"), fmt.Sprintf("#%06x", theme.Color)).
+		InlineField(ctx.T("theme.footer"), footer))
+}
+
+func setThemeColor(ctx *Context) {
+	color, err := parseColor(ctx.String("color"))
+	if err != nil {
+		ctx.UsageError(err)
+		return
+	}
+	// Discord treats 0 as no color at all.
+	if color == 0 {
+		color = 1
+	}
+	changeTheme(ctx, func(theme *embedTheme) { theme.Color = color })
+}
+
+func setThemeFooter(ctx *Context) {
+	footer := ctx.String("text")
+	if utf8.RuneCountInString(footer) > maxThemeFooter {
+		ctx.Say("theme.footer_too_long", maxThemeFooter)
+		return
+	}
+	changeTheme(ctx, func(theme *embedTheme) { theme.Footer = footer })
+}
+
+func resetTheme(ctx *Context) {
+	changeTheme(ctx, func(theme *embedTheme) { *theme = embedTheme{Color: defaultEmbedColor} })
+}
+
+func changeTheme(ctx *Context, change func(theme *embedTheme)) {
+	themesMutex.Lock()
+	theme, ok := guildThemes[ctx.GuildID]
+	if !ok {
+		theme = &embedTheme{Color: defaultEmbedColor}
+		guildThemes[ctx.GuildID] = theme
+	}
+	change(theme)
+	err := saveData("themes", guildThemes)
+	themesMutex.Unlock()
+	if err != nil {
+		log.Println("Error saving themes,", err)
+		ctx.Say("theme.save_failed")
+		return
+	}
+	showTheme(ctx)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEmbedIsImmutable(t *testing.T) {
+	base := newEmbed("Base").Field("shared", "1")
+	first := base.Field("first", "2")
+	second := base.Field("second", "3").Title("Second")
+
+	if got := base.Build("g1"); got.Title != "Base" || len(got.Fields) != 1 {
+		t.Errorf("base = %q with %d fields, want it unchanged", got.Title, len(got.Fields))
+	}
+	if got := first.Build("g1"); len(got.Fields) != 2 || got.Fields[1].Name != "first" {
+		t.Errorf("first has fields %v", got.Fields)
+	}
+	if got := second.Build("g1"); got.Title != "Second" || got.Fields[1].Name != "second" {
+		t.Errorf("second = %q with fields %v", got.Title, got.Fields)
+	}
+}
+
+func TestEmbedLimits(t *testing.T) {
+	embed := newEmbed(strings.Repeat("т", 300)).Description(strings.Repeat("d", 5000))
+	for i := 0; i < 30; i++ {
+		embed = embed.Field("", strings.Repeat("v", 1100))
+	}
+	built := embed.Build("g1")
+
+	if n := utf8.RuneCountInString(built.Title); n != embedTitleLimit || !strings.HasSuffix(built.Title, "…") {
+		t.Errorf("title has %d characters, want %d ending in …", n, embedTitleLimit)
+	}
+	if len(built.Fields) == 0 || len(built.Fields) > embedFieldLimit {
+		t.Errorf("%d fields, want some but at most %d", len(built.Fields), embedFieldLimit)
+	}
+	if built.Fields[0].Name != "\u200b" || utf8.RuneCountInString(built.Fields[0].Value) != embedFieldValueLimit {
+		t.Errorf("field = %q, %d characters, want a blank name and %d characters",
+			built.Fields[0].Name, utf8.RuneCountInString(built.Fields[0].Value), embedFieldValueLimit)
+	}
+	if n := embedLength(built); n > embedTotalLimit {
+		t.Errorf("embed has %d characters, want at most %d", n, embedTotalLimit)
+	}
+}
+
+func TestEmbedTheme(t *testing.T) {
+	oldThemes := guildThemes
+	guildThemes = map[string]*embedTheme{"g1": {Color: 0xff8800, Footer: "Radio"}}
+	defer func() { guildThemes = oldThemes }()
+
+	themed := newEmbed("Hi").Build("g1")
+	if themed.Color != 0xff8800 || themed.Footer == nil || themed.Footer.Text != "Radio" {
+		t.Errorf("themed embed = color %06x, footer %v, want the guild's theme", themed.Color, themed.Footer)
+	}
+	if own := newEmbed("Hi").Color(0x123456).Footer("Mine").Build("g1"); own.Color != 0x123456 || own.Footer.Text != "Mine" {
+		t.Errorf("embed's own color and footer lost to the theme: %06x %q", own.Color, own.Footer.Text)
+	}
+	if plain := newEmbed("Hi").Build("g2"); plain.Color != defaultEmbedColor || plain.Footer != nil {
+		t.Errorf("default theme = color %06x, footer %v", plain.Color, plain.Footer)
+	}
+
+	for text, want := range map[string]int{"#ff8800": 0xff8800, "0x00FF00": 0x00ff00, "abcdef": 0xabcdef} {
+		if got, err := parseColor(text); err != nil || got != want {
+			t.Errorf("parseColor(%q) = %06x, %v, want %06x", text, got, err, want)
+		}
+	}
+	if _, err := parseColor("orange"); err == nil {
+		t.Error("parseColor(orange) succeeded")
+	}
+}
@@ -3,9 +3,10 @@ module dmasik
 go 1.14
 
 require (
-	github.com/bwmarrin/dgvoice v0.0.0-20170706020935-3c939eca8b2f // indirect
-	github.com/bwmarrin/discordgo v0.20.3 // indirect
-	github.com/joho/godotenv v1.3.0 // indirect
-	github.com/rylio/ytdl v0.6.3 // indirect
-	layeh.com/gopus v0.0.0-20161224163843-0ebf989153aa // indirect
+	github.com/bwmarrin/discordgo v0.28.1
+	github.com/joho/godotenv v1.3.0
+	github.com/rylio/ytdl v0.6.3
+	golang.org/x/image v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+	layeh.com/gopus v0.0.0-20161224163843-0ebf989153aa
 )
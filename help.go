@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// showHelp lists the commands one category per page, or explains a single
+// command or sub-command.
+func showHelp(ctx *Context) {
+	topic := strings.Fields(ctx.String("command"))
+	if len(topic) == 0 {
+		sendHelpPage(ctx, 1)
+		return
+	}
+	if page, err := strconv.Atoi(topic[0]); err == nil && len(topic) == 1 {
+		sendHelpPage(ctx, page)
+		return
+	}
+
+	name := topic[0]
+	for _, prefix := range prefixesFor(ctx.GuildID) {
+		if rest, ok := cutPrefixFold(name, prefix); ok && rest != "" {
+			name = rest
+			break
+		}
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		ctx.Say("help.unknown_command", name)
+		return
+	}
+	path := []string{cmd.Name}
+	for _, name := range topic[1:] {
+		sub := cmd.subcommand(name)
+		if sub == nil {
+			ctx.Say("help.unknown_subcommand", strings.Join(path, " "), name)
+			return
+		}
+		cmd = sub
+		path = append(path, sub.Name)
+	}
+	ctx.ReplyEmbed(commandHelpEmbed(ctx, cmd, path))
+}
+
+// helpPages groups the registry by category, in commandCategories order.
+func helpPages() [][]*Command {
+	var pages [][]*Command
+	for _, category := range commandCategories {
+		var page []*Command
+		for _, cmd := range commandList {
+			if cmd.Category == category {
+				page = append(page, cmd)
+			}
+		}
+		if len(page) > 0 {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+func sendHelpPage(ctx *Context, page int) {
+	pages := helpPages()
+	paginate(ctx, len(pages), page, func(page int) (Embed, []*discordgo.File) {
+		var list strings.Builder
+		for _, cmd := range pages[page-1] {
+			fmt.Fprintf(&list, "**%s** — %s", cmd.Usage, describe(ctx, cmd, []string{cmd.Name}))
+			if len(cmd.Aliases) > 0 {
+				list.WriteString(" " + ctx.T("help.also", strings.Join(cmd.Aliases, ", .")))
+			}
+			list.WriteString("\n")
+		}
+		category := pages[page-1][0].Category
+		return newEmbed(ctx.T("help.title", ctx.T("category."+category))).
+			Description(list.String()).
+			Footer(ctx.T("help.footer")), nil
+	})
+}
+
+// commandHelpEmbed explains the command at path.
+func commandHelpEmbed(ctx *Context, cmd *Command, path []string) Embed {
+	embed := newEmbed("."+strings.Join(path, " ")).
+		Description(describe(ctx, cmd, path)).
+		Field(ctx.T("help.usage"), "`"+cmd.Usage+"`")
+	if len(cmd.Aliases) > 0 {
+		embed = embed.Field(ctx.T("help.aliases"), strings.Join(cmd.Aliases, ", "))
+	}
+
+	var subcommands strings.Builder
+	var restricted []string
+	for _, sub := range cmd.Subcommands {
+		fmt.Fprintf(&subcommands, "`%s` — %s\n", sub.Usage, describe(ctx, sub, append(path[:len(path):len(path)], sub.Name)))
+		if sub.Level > cmd.Level {
+			restricted = append(restricted, ctx.T("help.restricted", sub.Name, sub.Level.who(ctx)))
+		}
+	}
+	if subcommands.Len() > 0 {
+		embed = embed.Field(ctx.T("help.subcommands"), subcommands.String())
+	}
+	if len(cmd.Examples) > 0 {
+		embed = embed.Field(ctx.T("help.examples"), "`"+strings.Join(cmd.Examples, "`\n`")+"`")
+	}
+
+	if cmd.Cooldown.Per > 0 {
+		embed = embed.Field(ctx.T("help.cooldown"), strings.Title(cmd.Cooldown.describe(ctx)))
+	}
+	permissions := strings.Title(cmd.Level.who(ctx))
+	if len(restricted) > 0 {
+		permissions += ", " + strings.Join(restricted, ", ")
+	}
+	return embed.Field(ctx.T("help.permissions"), permissions)
+}
+
+// describe is what the command at path does, from the help.<path>
+// message when the guild's language has one. The registry's English
+// description is the fallback.
+func describe(ctx *Context, cmd *Command, path []string) string {
+	key := "help." + strings.Join(path, ".")
+	if _, ok := lookup(localeOf(ctx.GuildID), key); ok {
+		return ctx.T(key)
+	}
+	return cmd.Description
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dmasik/lyrics"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	karaokeTick        = time.Second
+	karaokeLinesBefore = 1
+	karaokeLinesAfter  = 3
+	lyricsMaxLength    = 4000
+)
+
+var (
+	karaokeGuilds = map[string]bool{}
+	karaokeMutex  sync.Mutex
+)
+
+// loadTrackLyrics looks for a sibling .lrc file first and falls back to a
+// lyrics tag embedded in the audio file.
+func loadTrackLyrics(file string) (*lyrics.Lyrics, error) {
+	lrc, err := os.Open(strings.TrimSuffix(file, filepath.Ext(file)) + ".lrc")
+	if err == nil {
+		defer lrc.Close()
+		return lyrics.Parse(lrc)
+	}
+
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format_tags:stream_tags", "-of", "json", file).Output()
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return nil, err
+	}
+	tags := []map[string]string{probe.Format.Tags}
+	for _, stream := range probe.Streams {
+		tags = append(tags, stream.Tags)
+	}
+	for _, t := range tags {
+		for key, value := range t {
+			// ID3 USLT comes out as "lyrics-eng" and the like.
+			if strings.HasPrefix(strings.ToLower(key), "lyrics") || strings.EqualFold(key, "unsyncedlyrics") {
+				return lyrics.ParseString(value)
+			}
+		}
+	}
+	return nil, lyrics.ErrEmpty
+}
+
+func showLyrics(ctx *Context) {
+	var file string
+	if ctx.Has("lib id") {
+		id := ctx.Int("lib id")
+		library, _ := scanLibrary()
+		if id > len(library) {
+			ctx.UsageError(&ArgError{Arg: "lib id", Err: localErr("args.between", "1", strconv.Itoa(len(library)))})
+			return
+		}
+		file = library[id-1].Path
+	} else {
+		song, ok := currentSong(ctx.GuildID)
+		if !ok || song.Type != "file" {
+			ctx.Say("library.nothing_playing.lyrics")
+			return
+		}
+		file = song.Link
+	}
+
+	words, err := loadTrackLyrics(file)
+	if err != nil {
+		if err != lyrics.ErrEmpty {
+			log.Println("Error loading lyrics for", file, err)
+		}
+		ctx.Say("lyrics.none", filepath.Base(file))
+		return
+	}
+	text := []rune(words.Text())
+	if len(text) > lyricsMaxLength {
+		text = append(text[:lyricsMaxLength], '…')
+	}
+	ctx.ReplyEmbed(newEmbed(lyricsTitle(words, file)).Description(string(text)))
+}
+
+func lyricsTitle(words *lyrics.Lyrics, file string) string {
+	switch {
+	case words.Title != "" && words.Artist != "":
+		return words.Artist + " - " + words.Title
+	case words.Title != "":
+		return words.Title
+	}
+	return filepath.Base(file)
+}
+
+// startKaraoke posts the synced lyrics of the current song and keeps
+// editing the message so the line being sung is highlighted.
+func startKaraoke(ctx *Context) {
+	song, ok := currentSong(ctx.GuildID)
+	voice, _ := findVoiceConnection(ctx.GuildID, "")
+	if !ok || song.Type != "file" || voice.Mixer == nil {
+		ctx.Say("karaoke.needs_track")
+		return
+	}
+	words, err := loadTrackLyrics(song.Link)
+	if err != nil || !words.Synced {
+		ctx.Say("karaoke.not_synced")
+		return
+	}
+
+	karaokeMutex.Lock()
+	if karaokeGuilds[ctx.GuildID] {
+		karaokeMutex.Unlock()
+		ctx.Say("karaoke.running")
+		return
+	}
+	karaokeGuilds[ctx.GuildID] = true
+	karaokeMutex.Unlock()
+
+	title := lyricsTitle(words, song.Link)
+	message, err := sendMessage(ctx.Session, ctx.ChannelID, karaokeFrame(title, words, -1))
+	if err != nil {
+		log.Println(err)
+		karaokeMutex.Lock()
+		delete(karaokeGuilds, ctx.GuildID)
+		karaokeMutex.Unlock()
+		return
+	}
+
+	goSafe(func() {
+		defer func() {
+			karaokeMutex.Lock()
+			delete(karaokeGuilds, ctx.GuildID)
+			karaokeMutex.Unlock()
+		}()
+		shown := -1
+		ticker := time.NewTicker(karaokeTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			current, ok := currentSong(ctx.GuildID)
+			if !ok || current.Link != song.Link {
+				editMessage(ctx.Session, discordgo.NewMessageEdit(ctx.ChannelID, message.ID).SetContent(tr(ctx.GuildID, "karaoke.finished", title)))
+				return
+			}
+			line := words.LineAt(voice.Mixer.Position())
+			if line == shown {
+				continue
+			}
+			shown = line
+			_, err := editMessage(ctx.Session, discordgo.NewMessageEdit(ctx.ChannelID, message.ID).SetContent(karaokeFrame(title, words, line)))
+			if err != nil {
+				log.Println("Error updating karaoke,", err)
+				return
+			}
+		}
+	})
+}
+
+func karaokeFrame(title string, words *lyrics.Lyrics, current int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎤 **%s**\n\n", title)
+	from, to := current-karaokeLinesBefore, current+karaokeLinesAfter
+	if from < 0 {
+		from = 0
+	}
+	if to >= len(words.Lines) {
+		to = len(words.Lines) - 1
+	}
+	for i := from; i <= to; i++ {
+		text := words.Lines[i].Text
+		if text == "" {
+			text = "♪"
+		}
+		if i == current {
+			b.WriteString("▶ **" + text + "**\n")
+		} else {
+			b.WriteString(text + "\n")
+		}
+	}
+	return b.String()
+}
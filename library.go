@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	libraryPath = "./audio"
+
+	// Anything else in the library folder, like .lrc lyrics, is not a track.
+	libraryExtensions = map[string]bool{
+		".mp3": true, ".wav": true, ".ogg": true, ".opus": true,
+		".flac": true, ".m4a": true, ".aac": true, ".webm": true,
+	}
+)
+
+type libraryTrack struct {
+	ID       int
+	Path     string
+	Name     string
+	Title    string
+	Artist   string
+	Category string
+}
+
+// scanLibrary walks libraryPath and returns every audio file in it. IDs
+// start at 1 and follow walk order, so they are stable as long as the
+// folder doesn't change. Files named "Artist - Title.ext" get both fields
+// filled, the first sub-folder is used as the category.
+func scanLibrary() ([]libraryTrack, error) {
+	var tracks []libraryTrack
+
+	err := filepath.Walk(libraryPath,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !libraryExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			track := libraryTrack{
+				ID:    len(tracks) + 1,
+				Path:  path,
+				Name:  info.Name(),
+				Title: strings.TrimSuffix(info.Name(), filepath.Ext(info.Name())),
+			}
+			if parts := strings.SplitN(track.Title, " - ", 2); len(parts) == 2 {
+				track.Artist = strings.TrimSpace(parts[0])
+				track.Title = strings.TrimSpace(parts[1])
+			}
+			if rel, err := filepath.Rel(libraryPath, path); err == nil {
+				if dirs := strings.Split(filepath.Dir(rel), string(filepath.Separator)); dirs[0] != "." {
+					track.Category = dirs[0]
+				}
+			}
+			tracks = append(tracks, track)
+			return nil
+		})
+	return tracks, err
+}
+
+// probeDuration asks ffprobe how long a file plays.
+func probeDuration(file string) (time.Duration, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", file).Output()
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
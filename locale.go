@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Replies come from message catalogs in <localesPath>/<language>/<persona>.json.
+// A persona only needs the messages it words differently, the rest come
+// from the language's neutral catalog and then from English. Guilds that
+// didn't pick a locale get the configured defaults.
+var (
+	localesPath     = "./locales"
+	defaultLanguage = "en"
+	defaultPersona  = "uwu"
+)
+
+const (
+	neutralPersona   = "neutral"
+	fallbackLanguage = "en"
+)
+
+// message is one catalog entry: a plain format or, for messages about a
+// count, one format per plural category ("one", "few", "many", "other").
+type message map[string]string
+
+func (m *message) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		*m = message{"other": text}
+		return nil
+	}
+	var forms map[string]string
+	if err := json.Unmarshal(raw, &forms); err != nil {
+		return fmt.Errorf("a message must be a string or an object of plural forms")
+	}
+	if forms["other"] == "" {
+		return fmt.Errorf("plural forms need an \"other\" form")
+	}
+	*m = forms
+	return nil
+}
+
+var (
+	catalogs      map[string]map[string]map[string]message
+	catalogsErr   error
+	catalogsOnce  sync.Once
+	guildLocales  = map[string]*guildLocale{}
+	localesMutex  sync.Mutex
+	pluralMatches = map[string]func(n int) string{
+		"en": englishPlural,
+		"ru": russianPlural,
+	}
+)
+
+// guildLocale is the language and persona a guild picked.
+type guildLocale struct {
+	Language string `json:"language"`
+	Persona  string `json:"persona"`
+}
+
+// loadCatalogs reads every catalog under localesPath, once. Later calls
+// return the first outcome.
+func loadCatalogs() error {
+	catalogsOnce.Do(func() {
+		catalogs, catalogsErr = readCatalogs(localesPath)
+	})
+	return catalogsErr
+}
+
+func readCatalogs(root string) (map[string]map[string]map[string]message, error) {
+	languages, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]map[string]map[string]message{}
+	for _, language := range languages {
+		if !language.IsDir() {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(root, language.Name(), "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			raw, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			var catalog map[string]message
+			if err := json.Unmarshal(raw, &catalog); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if found[language.Name()] == nil {
+				found[language.Name()] = map[string]map[string]message{}
+			}
+			found[language.Name()][strings.TrimSuffix(filepath.Base(file), ".json")] = catalog
+		}
+	}
+	if _, ok := found[fallbackLanguage][neutralPersona]; !ok {
+		return nil, fmt.Errorf("%s has no %s/%s.json to fall back to", root, fallbackLanguage, neutralPersona)
+	}
+	return found, nil
+}
+
+// languages and personas list what the catalogs offer.
+func languages() []string {
+	loadCatalogs()
+	var names []string
+	for language := range catalogs {
+		names = append(names, language)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func personas(language string) []string {
+	loadCatalogs()
+	var names []string
+	for persona := range catalogs[language] {
+		names = append(names, persona)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func localeOf(guild string) guildLocale {
+	localesMutex.Lock()
+	defer localesMutex.Unlock()
+	locale := guildLocale{Language: defaultLanguage, Persona: defaultPersona}
+	if chosen, ok := guildLocales[guild]; ok {
+		if chosen.Language != "" {
+			locale.Language = chosen.Language
+		}
+		if chosen.Persona != "" {
+			locale.Persona = chosen.Persona
+		}
+	}
+	return locale
+}
+
+// lookup finds key in the persona's catalog, the language's neutral one
+// or the English neutral one, in that order.
+func lookup(locale guildLocale, key string) (message, bool) {
+	if err := loadCatalogs(); err != nil {
+		return nil, false
+	}
+	for _, at := range []guildLocale{locale, {locale.Language, neutralPersona}, {fallbackLanguage, neutralPersona}} {
+		if m, ok := catalogs[at.Language][at.Persona][key]; ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// translate formats the message key with args. When the message has
+// plural forms the first argument is the count that picks one. Unknown
+// keys come back as they are, so a missing message shows what's missing.
+func translate(locale guildLocale, key string, args ...interface{}) string {
+	m, ok := lookup(locale, key)
+	if !ok {
+		log.Println("Missing message", key, "for", locale.Language, locale.Persona)
+		return key
+	}
+	format := m["other"]
+	if len(m) > 1 && len(args) > 0 {
+		if n, ok := args[0].(int); ok {
+			if form, ok := m[pluralCategory(locale.Language, n)]; ok {
+				format = form
+			}
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// tr is translate for a guild, for messages sent outside of a command.
+func tr(guild string, key string, args ...interface{}) string {
+	return translate(localeOf(guild), key, args...)
+}
+
+func pluralCategory(language string, n int) string {
+	if match, ok := pluralMatches[language]; ok {
+		return match(n)
+	}
+	return englishPlural(n)
+}
+
+func englishPlural(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func russianPlural(n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return "one"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "few"
+	default:
+		return "many"
+	}
+}
+
+// localError is an error meant for users. Its text comes from the
+// catalogs, Error gives the English one for logs.
+type localError struct {
+	key  string
+	args []interface{}
+}
+
+func localErr(key string, args ...interface{}) error {
+	return &localError{key: key, args: args}
+}
+
+func (e *localError) Error() string {
+	return translate(guildLocale{fallbackLanguage, neutralPersona}, e.key, e.args...)
+}
+
+// T is the message key in the language and persona of the guild.
+func (ctx *Context) T(key string, args ...interface{}) string {
+	return translate(localeOf(ctx.GuildID), key, args...)
+}
+
+// Say replies with the message key.
+func (ctx *Context) Say(key string, args ...interface{}) {
+	ctx.Reply(ctx.T(key, args...))
+}
+
+// Explain is err as text for the author, translated when it came from the
+// catalogs.
+func (ctx *Context) Explain(err error) string {
+	switch e := err.(type) {
+	case *ArgError:
+		if e.Arg == "" {
+			return ctx.Explain(e.Err)
+		}
+		return e.Arg + " " + ctx.Explain(e.Err)
+	case *localError:
+		args := make([]interface{}, len(e.args))
+		for i, arg := range e.args {
+			args[i] = arg
+			if inner, ok := arg.(error); ok {
+				args[i] = ctx.Explain(inner)
+			}
+		}
+		return ctx.T(e.key, args...)
+	}
+	return err.Error()
+}
+
+func showLanguage(ctx *Context) {
+	ctx.Say("lang.current", localeOf(ctx.GuildID).Language, strings.Join(languages(), ", "))
+}
+
+// setLanguage shows the guild's language, or for admins changes it.
+func setLanguage(ctx *Context) {
+	if !ctx.Has("language") {
+		showLanguage(ctx)
+		return
+	}
+	if !ctx.atLeast(PermAdmin) {
+		return
+	}
+	language := strings.ToLower(ctx.String("language"))
+	if !containsString(languages(), language) {
+		ctx.UsageError(&ArgError{Arg: "language", Err: localErr("args.choices", strings.Join(languages(), ", "))})
+		return
+	}
+	changeLocale(ctx, func(locale *guildLocale) { locale.Language = language })
+}
+
+func showPersona(ctx *Context) {
+	locale := localeOf(ctx.GuildID)
+	ctx.Say("persona.current", locale.Persona, strings.Join(personas(locale.Language), ", "))
+}
+
+// setPersona shows the guild's persona, or for admins changes it.
+func setPersona(ctx *Context) {
+	if !ctx.Has("persona") {
+		showPersona(ctx)
+		return
+	}
+	if !ctx.atLeast(PermAdmin) {
+		return
+	}
+	persona := strings.ToLower(ctx.String("persona"))
+	available := personas(localeOf(ctx.GuildID).Language)
+	if !containsString(available, persona) {
+		ctx.UsageError(&ArgError{Arg: "persona", Err: localErr("args.choices", strings.Join(available, ", "))})
+		return
+	}
+	changeLocale(ctx, func(locale *guildLocale) { locale.Persona = persona })
+}
+
+func changeLocale(ctx *Context, change func(locale *guildLocale)) {
+	localesMutex.Lock()
+	locale, ok := guildLocales[ctx.GuildID]
+	if !ok {
+		locale = &guildLocale{}
+		guildLocales[ctx.GuildID] = locale
+	}
+	change(locale)
+	err := saveData("locales", guildLocales)
+	localesMutex.Unlock()
+	if err != nil {
+		log.Println("Error saving locales,", err)
+		ctx.Say("locale.save_failed")
+		return
+	}
+	ctx.Say("locale.updated")
+}
+
+// completeLanguage and completePersona suggest what the catalogs offer.
+func completeLanguage(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	return matchingChoices(languages(), partial)
+}
+
+func completePersona(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	return matchingChoices(personas(localeOf(guild).Language), partial)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
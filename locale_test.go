@@ -0,0 +1,105 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRussianPlural(t *testing.T) {
+	for n, want := range map[int]string{
+		0: "many", 1: "one", 2: "few", 4: "few", 5: "many", 11: "many", 12: "many",
+		14: "many", 21: "one", 22: "few", 25: "many", 101: "one", 111: "many",
+	} {
+		if got := russianPlural(n); got != want {
+			t.Errorf("russianPlural(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	oldLocales := guildLocales
+	guildLocales = map[string]*guildLocale{
+		"ru":     {Language: "ru", Persona: "neutral"},
+		"ru uwu": {Language: "ru"},
+		"gone":   {Language: "xx", Persona: "nobody"},
+	}
+	defer func() { guildLocales = oldLocales }()
+
+	tests := []struct {
+		guild string
+		key   string
+		args  []interface{}
+		want  string
+	}{
+		{"g1", "page.out_of_range", []interface{}{3}, "oWu there are only 3 pages"},
+		{"g1", "page.out_of_range", []interface{}{1}, "oWu there is only 1 page"},
+		{"ru", "page.out_of_range", []interface{}{1}, "Всего 1 страница"},
+		{"ru", "page.out_of_range", []interface{}{3}, "Всего 3 страницы"},
+		{"ru", "page.out_of_range", []interface{}{11}, "Всего 11 страниц"},
+		{"ru", "page.out_of_range", []interface{}{21}, "Всего 21 страница"},
+		{"ru", "quiz.correct", []interface{}{5, "Маша", 2.5, "Song"}, "✅ Маша угадал за 2.5 с (+5): **Song**"},
+		// Personas fall back to their language, then to English.
+		{"ru uwu", "queue.empty", nil, "Очередь пуста"},
+		{"gone", "queue.empty", nil, "The queue is empty"},
+		{"g1", "no.such.key", nil, "no.such.key"},
+	}
+	for _, tt := range tests {
+		if got := tr(tt.guild, tt.key, tt.args...); got != tt.want {
+			t.Errorf("tr(%q, %q, %v) = %q, want %q", tt.guild, tt.key, tt.args, got, tt.want)
+		}
+	}
+
+	err := &ArgError{Arg: "count", Err: localErr("args.between", "1", "5")}
+	if got := err.Error(); got != "count must be between 1 and 5" {
+		t.Errorf("ArgError = %q, want the English text", got)
+	}
+	ctx := &Context{GuildID: "ru"}
+	if got := ctx.Explain(err); got != "count должен быть от 1 до 5" {
+		t.Errorf("Explain = %q, want the Russian text", got)
+	}
+}
+
+var formatVerb = regexp.MustCompile(`%(?:\[\d+\])?[-+# 0]*\d*(?:\.\d*)?(?:\[\d+\])?([a-zA-Z%])`)
+
+// verbs lists the verbs of format, so catalogs can be checked against the
+// English one whatever order they take their arguments in.
+func verbs(format string) string {
+	var found []string
+	for _, match := range formatVerb.FindAllStringSubmatch(format, -1) {
+		if match[1] != "%" {
+			found = append(found, match[1])
+		}
+	}
+	sort.Strings(found)
+	return strings.Join(found, "")
+}
+
+func TestCatalogs(t *testing.T) {
+	if err := loadCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+	english := catalogs[fallbackLanguage][neutralPersona]
+	for language, personas := range catalogs {
+		for persona, catalog := range personas {
+			for key, m := range catalog {
+				base, ok := english[key]
+				if !ok {
+					if !strings.HasPrefix(key, "help.") {
+						t.Errorf("%s/%s has %s, which English doesn't", language, persona, key)
+					}
+					continue
+				}
+				for form, format := range m {
+					if got, want := verbs(format), verbs(base["other"]); got != want {
+						t.Errorf("%s/%s %s %s takes %q, English takes %q", language, persona, key, form, got, want)
+					}
+				}
+				if language == "ru" && len(m) > 1 && (m["one"] == "" || m["few"] == "" || m["many"] == "") {
+					t.Errorf("%s/%s %s is missing Russian plural forms", language, persona, key)
+				}
+			}
+		}
+	}
+}
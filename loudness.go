@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	loudnessTarget  = -16.0 // LUFS everything is normalized to
+	loudnessCeiling = -1.0  // dBFS the normalized peak must stay under
+	loudnessFloor   = -70.0 // LUFS reported for silence, the absolute gate
+	peakFloor       = -120.0
+
+	loudnessBlock = 400 * time.Millisecond
+	loudnessStep  = 100 * time.Millisecond
+)
+
+// trackLoudness is the EBU R128 analysis of one library file. Size and
+// ModTime tell whether the file changed since it was analyzed.
+type trackLoudness struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Integrated float64   `json:"integrated"`
+	Peak       float64   `json:"peak"`
+}
+
+var (
+	libraryIndex      = map[string]*trackLoudness{}
+	libraryIndexMutex sync.Mutex
+	libraryAnalyzing  bool
+)
+
+// biquad is a direct form I second order filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the ITU-R BS.1770 pre-filter and RLB filter for 48kHz.
+func kWeighting() (*biquad, *biquad) {
+	return &biquad{b0: 1.53512485958697, b1: -2.69169618940638, b2: 1.19839281085285, a1: -1.69065929318241, a2: 0.73248077421585},
+		&biquad{b0: 1, b1: -2, b2: 1, a1: -1.99004745483398, a2: 0.99007225036621}
+}
+
+// measureLoudness computes integrated loudness (LUFS) and sample peak
+// (dBFS) of interleaved stereo float samples at audioFrameRate.
+func measureLoudness(samples io.Reader) (float64, float64, error) {
+	var filters [audioChannels][2]*biquad
+	for ch := range filters {
+		filters[ch][0], filters[ch][1] = kWeighting()
+	}
+
+	stepSamples := int(loudnessStep.Seconds() * float64(audioFrameRate))
+	blockSteps := int(loudnessBlock / loudnessStep)
+
+	// Mean square energy of every 100ms step, blocks are made of 4 steps.
+	var steps []float64
+	var energy float64
+	var count int
+	peak := 0.0
+	frame := make([]float32, audioChannels)
+	for {
+		err := binary.Read(samples, binary.LittleEndian, frame)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		for ch, sample := range frame {
+			if abs := math.Abs(float64(sample)); abs > peak {
+				peak = abs
+			}
+			weighted := filters[ch][1].process(filters[ch][0].process(float64(sample)))
+			energy += weighted * weighted
+		}
+		count++
+		if count == stepSamples {
+			steps = append(steps, energy/float64(stepSamples))
+			energy, count = 0, 0
+		}
+	}
+
+	var blocks []float64
+	for i := 0; i+blockSteps <= len(steps); i++ {
+		var sum float64
+		for _, e := range steps[i : i+blockSteps] {
+			sum += e
+		}
+		blocks = append(blocks, sum/float64(blockSteps))
+	}
+
+	loudness := func(e float64) float64 { return -0.691 + 10*math.Log10(e) }
+	gatedMean := func(threshold float64) (float64, bool) {
+		var sum float64
+		var n int
+		for _, e := range blocks {
+			if loudness(e) > threshold {
+				sum += e
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, false
+		}
+		return sum / float64(n), true
+	}
+
+	peakDB := math.Max(20*math.Log10(peak), peakFloor)
+	absolute, ok := gatedMean(loudnessFloor)
+	if !ok {
+		return loudnessFloor, peakDB, nil
+	}
+	relative, ok := gatedMean(loudness(absolute) - 10)
+	if !ok {
+		return loudnessFloor, peakDB, nil
+	}
+	return loudness(relative), peakDB, nil
+}
+
+func analyzeLoudness(file string) (float64, float64, error) {
+	run := exec.Command("ffmpeg", "-i", file, "-f", "f32le", "-ar", strconv.Itoa(audioFrameRate), "-ac", strconv.Itoa(audioChannels), "pipe:1")
+	out, err := run.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = run.Start()
+	if err != nil {
+		return 0, 0, err
+	}
+	integrated, peak, err := measureLoudness(bufio.NewReaderSize(out, 65536))
+	if waitErr := run.Wait(); err == nil {
+		err = waitErr
+	}
+	return integrated, peak, err
+}
+
+// analyzeTrack measures file and stores the result in the library index,
+// skipping files that didn't change since the last analysis.
+func analyzeTrack(file string) error {
+	file = filepath.Clean(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	libraryIndexMutex.Lock()
+	entry, ok := libraryIndex[file]
+	libraryIndexMutex.Unlock()
+	if ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return nil
+	}
+
+	integrated, peak, err := analyzeLoudness(file)
+	if err != nil {
+		return err
+	}
+	libraryIndexMutex.Lock()
+	defer libraryIndexMutex.Unlock()
+	libraryIndex[file] = &trackLoudness{
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		Integrated: integrated,
+		Peak:       peak,
+	}
+	return saveData("library", libraryIndex)
+}
+
+// normalizationGain is the gain in dB that brings file to loudnessTarget
+// without pushing its peak over loudnessCeiling. Unanalyzed files get 0.
+func normalizationGain(file string) float64 {
+	libraryIndexMutex.Lock()
+	entry, ok := libraryIndex[filepath.Clean(file)]
+	libraryIndexMutex.Unlock()
+	if !ok || entry.Integrated <= loudnessFloor {
+		return 0
+	}
+	gain := loudnessTarget - entry.Integrated
+	if entry.Peak+gain > loudnessCeiling {
+		gain = loudnessCeiling - entry.Peak
+	}
+	return gain
+}
+
+func trackLoudnessInfo(file string) (trackLoudness, bool) {
+	libraryIndexMutex.Lock()
+	defer libraryIndexMutex.Unlock()
+	entry, ok := libraryIndex[filepath.Clean(file)]
+	if !ok {
+		return trackLoudness{}, false
+	}
+	return *entry, true
+}
+
+// analyzeLibrary runs the loudness analysis over the whole library in the
+// background and reports back in the channel when done.
+func analyzeLibrary(ctx *Context) {
+	libraryIndexMutex.Lock()
+	if libraryAnalyzing {
+		libraryIndexMutex.Unlock()
+		ctx.Say("loudness.running")
+		return
+	}
+	libraryAnalyzing = true
+	libraryIndexMutex.Unlock()
+
+	library, err := scanLibrary()
+	if err != nil {
+		log.Println(err)
+	}
+	ctx.Say("loudness.started", len(library))
+
+	goSafe(func() {
+		defer func() {
+			libraryIndexMutex.Lock()
+			libraryAnalyzing = false
+			libraryIndexMutex.Unlock()
+		}()
+		failed := 0
+		for _, track := range library {
+			err := analyzeTrack(track.Path)
+			if err != nil {
+				log.Println("Error analyzing", track.Path, err)
+				failed++
+			}
+		}
+		ctx.Say("loudness.done", len(library), failed)
+	})
+}
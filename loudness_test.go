@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// tone is an interleaved stereo sine of peak level dbfs at audioFrameRate,
+// silence for dbfs below -200.
+func tone(hz float64, dbfs float64, d time.Duration) []float32 {
+	n := int(d.Seconds() * float64(audioFrameRate))
+	amplitude := math.Pow(10, dbfs/20)
+	if dbfs < -200 {
+		amplitude = 0
+	}
+	samples := make([]float32, 0, n*audioChannels)
+	for i := 0; i < n; i++ {
+		v := float32(amplitude * math.Sin(2*math.Pi*hz*float64(i)/float64(audioFrameRate)))
+		for ch := 0; ch < audioChannels; ch++ {
+			samples = append(samples, v)
+		}
+	}
+	return samples
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	const silent = -300
+	tests := []struct {
+		name     string
+		parts    [][]float32
+		loudness float64
+		peak     float64
+	}{
+		// EBU Tech 3341 case 1: a -23 dBFS 1 kHz stereo sine reads -23 LUFS.
+		{"reference sine", [][]float32{tone(1000, -23, 20*time.Second)}, -23, -23},
+		{"louder sine", [][]float32{tone(1000, -6, 10*time.Second)}, -6, -6},
+		{"silence", [][]float32{tone(1000, silent, 5*time.Second)}, loudnessFloor, peakFloor},
+		// Shorter than one 400ms block, so nothing to gate.
+		{"short clip", [][]float32{tone(1000, -10, 300*time.Millisecond)}, loudnessFloor, -10},
+		// Silence falls under the absolute gate and doesn't pull the
+		// result down.
+		{"sine and silence", [][]float32{tone(1000, -23, 10*time.Second), tone(1000, silent, 10*time.Second)}, -23, -23},
+		// -50 is over the absolute gate but more than 10 LU under the
+		// ungated mean, the relative gate drops it.
+		{"quiet part", [][]float32{tone(1000, -23, 10*time.Second), tone(1000, -50, 10*time.Second)}, -23, -23},
+		// -30 is within 10 LU of the mean, so it counts.
+		{"two levels", [][]float32{tone(1000, -20, 10*time.Second), tone(1000, -30, 10*time.Second)}, -22.6, -20},
+	}
+	for _, tt := range tests {
+		var in bytes.Buffer
+		for _, part := range tt.parts {
+			binary.Write(&in, binary.LittleEndian, part)
+		}
+		loudness, peak, err := measureLoudness(&in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if math.Abs(loudness-tt.loudness) > 0.1 {
+			t.Errorf("%s: loudness = %.2f LUFS, want %.1f", tt.name, loudness, tt.loudness)
+		}
+		if math.Abs(peak-tt.peak) > 0.1 {
+			t.Errorf("%s: peak = %.2f dBFS, want %.1f", tt.name, peak, tt.peak)
+		}
+	}
+}
+
+func TestKWeighting(t *testing.T) {
+	// The filters leave 1 kHz about 0.69 dB louder, cut the low end and
+	// lift the highs by about 4 dB.
+	for _, tt := range []struct {
+		hz   float64
+		gain float64
+	}{
+		{1000, 0.69},
+		{20, -13.3},
+		{10000, 4.4},
+	} {
+		pre, rlb := kWeighting()
+		var in, out float64
+		n := audioFrameRate * 2
+		for i := 0; i < n; i++ {
+			x := math.Sin(2 * math.Pi * tt.hz * float64(i) / float64(audioFrameRate))
+			y := rlb.process(pre.process(x))
+			if i >= n/2 {
+				in += x * x
+				out += y * y
+			}
+		}
+		if gain := 10 * math.Log10(out/in); math.Abs(gain-tt.gain) > 0.5 {
+			t.Errorf("gain at %v Hz = %.2f dB, want about %.1f", tt.hz, gain, tt.gain)
+		}
+	}
+}
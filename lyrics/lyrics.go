@@ -0,0 +1,185 @@
+// Package lyrics parses and validates song lyrics in the LRC format,
+// both synced ([mm:ss.xx] timestamps) and plain text.
+package lyrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrEmpty is returned for input without a single lyrics line.
+var ErrEmpty = errors.New("lyrics: no lyrics found")
+
+// ParseError points at the line that failed validation.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("lyrics: line %d: %s", e.Line, e.Msg)
+}
+
+// Line is a single lyrics line shown from Time on. Time is zero for plain
+// lyrics.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+type Lyrics struct {
+	Title  string
+	Artist string
+	Album  string
+	// Offset from the [offset:] tag, positive values show lines earlier.
+	Offset time.Duration
+	Synced bool
+	Lines  []Line
+}
+
+var (
+	tagPattern       = regexp.MustCompile(`^\[([a-zA-Z]+):(.*)\]$`)
+	timestampPattern = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	wordTimePattern  = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+	// Anything starting like a timestamp that timestampPattern rejected.
+	brokenTimestampPattern = regexp.MustCompile(`^\[\d[^\]]*\]?`)
+)
+
+// Parse reads LRC or plain lyrics from r. Synced and untimed lines can't
+// be mixed, lines in synced lyrics are sorted by time.
+func Parse(r io.Reader) (*Lyrics, error) {
+	lyrics := &Lyrics{}
+	var untimed []int
+
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if text == "" {
+			continue
+		}
+
+		if match := tagPattern.FindStringSubmatch(text); match != nil && !timestampPattern.MatchString(text) {
+			err := lyrics.setTag(strings.ToLower(match[1]), strings.TrimSpace(match[2]))
+			if err != nil {
+				return nil, &ParseError{Line: number, Msg: err.Error()}
+			}
+			continue
+		}
+
+		var times []time.Duration
+		for {
+			match := timestampPattern.FindStringSubmatch(text)
+			if match == nil {
+				break
+			}
+			t, err := parseTimestamp(match[1], match[2], match[3])
+			if err != nil {
+				return nil, &ParseError{Line: number, Msg: err.Error()}
+			}
+			times = append(times, t)
+			text = text[len(match[0]):]
+		}
+		if len(times) == 0 && brokenTimestampPattern.MatchString(text) {
+			return nil, &ParseError{Line: number, Msg: "malformed timestamp " + brokenTimestampPattern.FindString(text)}
+		}
+		text = strings.TrimSpace(wordTimePattern.ReplaceAllString(text, ""))
+
+		if len(times) == 0 {
+			untimed = append(untimed, number)
+			lyrics.Lines = append(lyrics.Lines, Line{Text: text})
+			continue
+		}
+		lyrics.Synced = true
+		for _, t := range times {
+			lyrics.Lines = append(lyrics.Lines, Line{Time: t, Text: text})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lyrics.Lines) == 0 {
+		return nil, ErrEmpty
+	}
+	if lyrics.Synced && len(untimed) > 0 {
+		return nil, &ParseError{Line: untimed[0], Msg: "line without timestamp in synced lyrics"}
+	}
+	if lyrics.Synced {
+		sort.SliceStable(lyrics.Lines, func(i, j int) bool { return lyrics.Lines[i].Time < lyrics.Lines[j].Time })
+	}
+	return lyrics, nil
+}
+
+// ParseString is Parse for lyrics already in memory.
+func ParseString(s string) (*Lyrics, error) {
+	return Parse(strings.NewReader(s))
+}
+
+func (l *Lyrics) setTag(tag string, value string) error {
+	switch tag {
+	case "ti":
+		l.Title = value
+	case "ar":
+		l.Artist = value
+	case "al":
+		l.Album = value
+	case "offset":
+		ms, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		if err != nil {
+			return fmt.Errorf("bad offset %q", value)
+		}
+		l.Offset = time.Duration(ms) * time.Millisecond
+	}
+	// Other ID tags (by, re, ve, length, ...) carry nothing we show.
+	return nil
+}
+
+func parseTimestamp(minutes string, seconds string, fraction string) (time.Duration, error) {
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	if s >= 60 {
+		return 0, fmt.Errorf("seconds out of range in %s:%s", minutes, seconds)
+	}
+	t := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if fraction != "" {
+		f, _ := strconv.Atoi(fraction)
+		// .5 is half a second, .05 is 50ms, .005 is 5ms.
+		for i := len(fraction); i < 3; i++ {
+			f *= 10
+		}
+		t += time.Duration(f) * time.Millisecond
+	}
+	return t, nil
+}
+
+// LineAt returns the index of the line being sung at playback position pos,
+// or -1 before the first line and for plain lyrics.
+func (l *Lyrics) LineAt(pos time.Duration) int {
+	if !l.Synced {
+		return -1
+	}
+	pos += l.Offset
+	i := sort.Search(len(l.Lines), func(i int) bool { return l.Lines[i].Time > pos })
+	return i - 1
+}
+
+// Text returns the lyrics as plain text, one line per line.
+func (l *Lyrics) Text() string {
+	var b strings.Builder
+	for i, line := range l.Lines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(line.Text)
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package lyrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *Lyrics
+	}{
+		{
+			name:  "synced with tags",
+			input: "[ti:Giorno's Theme]\n[ar:Yugo Kanno]\n[by:someone]\n\n[00:01.50]Il vento d'oro\n[00:10.00]Second line\n",
+			want: &Lyrics{
+				Title:  "Giorno's Theme",
+				Artist: "Yugo Kanno",
+				Synced: true,
+				Lines: []Line{
+					{Time: 1500 * time.Millisecond, Text: "Il vento d'oro"},
+					{Time: 10 * time.Second, Text: "Second line"},
+				},
+			},
+		},
+		{
+			name:  "repeated timestamps are sorted",
+			input: "[00:30.00][00:05.00]Chorus\n[00:10.00]Verse",
+			want: &Lyrics{
+				Synced: true,
+				Lines: []Line{
+					{Time: 5 * time.Second, Text: "Chorus"},
+					{Time: 10 * time.Second, Text: "Verse"},
+					{Time: 30 * time.Second, Text: "Chorus"},
+				},
+			},
+		},
+		{
+			name:  "fraction precision",
+			input: "[01:02]a\n[01:02.5]b\n[01:02.05]c\n[01:02.005]d",
+			want: &Lyrics{
+				Synced: true,
+				Lines: []Line{
+					{Time: 62 * time.Second, Text: "a"},
+					{Time: 62*time.Second + 5*time.Millisecond, Text: "d"},
+					{Time: 62*time.Second + 50*time.Millisecond, Text: "c"},
+					{Time: 62*time.Second + 500*time.Millisecond, Text: "b"},
+				},
+			},
+		},
+		{
+			name:  "enhanced word timings are stripped",
+			input: "[00:01.00]<00:01.00>Bruh <00:01.50>moment",
+			want: &Lyrics{
+				Synced: true,
+				Lines:  []Line{{Time: time.Second, Text: "Bruh moment"}},
+			},
+		},
+		{
+			name:  "offset and instrumental gap",
+			input: "[offset:+250]\n[00:01.00]Line\n[00:05.00]\n",
+			want: &Lyrics{
+				Offset: 250 * time.Millisecond,
+				Synced: true,
+				Lines: []Line{
+					{Time: time.Second, Text: "Line"},
+					{Time: 5 * time.Second, Text: ""},
+				},
+			},
+		},
+		{
+			name:  "plain lyrics with section markers",
+			input: "\ufeff[Chorus]\nFirst line\nSecond line\n",
+			want: &Lyrics{
+				Lines: []Line{
+					{Text: "[Chorus]"},
+					{Text: "First line"},
+					{Text: "Second line"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseString(tt.input)
+			if err != nil {
+				t.Fatalf("ParseString() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseString() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+	}{
+		{"seconds out of range", "[00:01.00]ok\n[00:75.00]bad", 2},
+		{"malformed timestamp", "[00:01.00]ok\n[0x:12.00]bad", 2},
+		{"bad offset", "[offset:soon]\n[00:01.00]ok", 1},
+		{"untimed line in synced lyrics", "[00:01.00]ok\nno time here\n[00:02.00]ok", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseString(tt.input)
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("ParseString() error = %v, want *ParseError", err)
+			}
+			if parseErr.Line != tt.wantLine {
+				t.Errorf("ParseError.Line = %d, want %d", parseErr.Line, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, input := range []string{"", "\n\n", "[ti:Only tags]\n[ar:Nobody]"} {
+		if _, err := ParseString(input); err != ErrEmpty {
+			t.Errorf("ParseString(%q) error = %v, want ErrEmpty", input, err)
+		}
+	}
+}
+
+func TestLineAt(t *testing.T) {
+	l, err := ParseString("[offset:500]\n[00:01.00]one\n[00:03.00]two\n[00:05.00]three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pos  time.Duration
+		want int
+	}{
+		{0, -1},
+		{499 * time.Millisecond, -1},
+		{500 * time.Millisecond, 0},
+		{2 * time.Second, 0},
+		{2500 * time.Millisecond, 1},
+		{time.Minute, 2},
+	}
+	for _, tt := range tests {
+		if got := l.LineAt(tt.pos); got != tt.want {
+			t.Errorf("LineAt(%v) = %d, want %d", tt.pos, got, tt.want)
+		}
+	}
+
+	plain, err := ParseString("just words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plain.LineAt(time.Minute); got != -1 {
+		t.Errorf("plain LineAt() = %d, want -1", got)
+	}
+}
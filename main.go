@@ -3,17 +3,17 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/bwmarrin/dgvoice"
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	"github.com/rylio/ytdl"
@@ -23,95 +23,371 @@ type Voice struct {
 	VoiceConnection *discordgo.VoiceConnection
 	Channel         string
 	Guild           string
-	PlayerStatus    int
+	Mixer           *Mixer
+	Recorder        *Recorder
 }
 
 type Song struct {
-	Link    string
-	Type    string
-	Guild   string
-	Channel string
+	Link      string
+	Type      string
+	Guild     string
+	Channel   string
+	Requester string
 }
 
 const ShellToUse string = "bash"
 
 var (
 	dg               *discordgo.Session
-	stopChannel      chan bool
-	commandArgs      []string
 	voiceConnections []Voice
 	queue            []Song
 
-	nowPlaying Song
-
-	discordPrefix = "."
-	commands      = map[string]func(*discordgo.Session, *discordgo.MessageCreate){
-		"text":       getText,
-		"ping":       pong,
-		"pong":       ping,
-		"connect":    connectToVC,
-		"disconnect": disconnectFromVoiceChannel,
-		"join":       connectToVC,
-		"leave":      disconnectFromVoiceChannel,
-		"j":          connectToVC,
-		"l":          disconnectFromVoiceChannel,
-		"bruh":       playBruhSound,
-		"stal":       playStalMusic,
-		"stop":       stopMusic,
-		"yt":         playYoutubeLink,
-		"play":       playAudioLink,
-		"library":    playLibraryMusic,
-		"lib":        playLibraryMusic,
-		"skip":       nextSong,
-		"next":       nextSong,
-		"flex":       flex,
-	}
-
-	IS_PLAYING     = 0
-	IS_NOT_PLAYING = 0
+	nowPlaying      = map[string]Song{}
+	nowPlayingMutex sync.Mutex
 
 	bruhSoundPath       = "./audio/bruh.opus"
 	stalMusicPath       = "./audio/stal.opus"
 	imageMeNaniFilePath = "./images/memes/Nani.png"
 	imageMeURL          = "https://avatars3.githubusercontent.com/u/22434204?s=460&u=cc62b75ba8a868b3c0af3b2b0ef7df7830963a5b&v=4"
+)
+
+const (
+	categoryMusic      = "music"
+	categorySoundboard = "soundboard"
+	categoryMemes      = "memes"
+	categoryFinance    = "finance"
+	categoryTools      = "tools"
+)
 
-	embedExample = &discordgo.MessageEmbed{
-		Author:      &discordgo.MessageEmbedAuthor{},
-		Color:       0x00ff00, // Green
-		Description: "This is a discordgo embed",
-		Fields: []*discordgo.MessageEmbedField{
-			&discordgo.MessageEmbedField{
-				Name:   "I am a field1",
-				Value:  "I am a value2",
-				Inline: true,
+// commandCategories is the order categories are listed in .help.
+var commandCategories = []string{categoryMusic, categorySoundboard, categoryMemes, categoryFinance, categoryTools}
+
+func init() {
+	for _, cmd := range []*Command{
+		// Music
+		{Name: "connect", Aliases: []string{"join", "j"}, Category: categoryMusic, Usage: ".join",
+			Description: "Joins your voice channel", Run: connectToVC},
+		{Name: "disconnect", Aliases: []string{"leave", "l"}, Category: categoryMusic, Usage: ".leave",
+			Description: "Leaves the voice channel", Level: PermDJ, Run: disconnectFromVoiceChannel},
+		{Name: "yt", Category: categoryMusic, Usage: ".yt <URL>", Description: "Plays the audio of a YouTube video",
+			Examples: []string{".yt https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+			Args:     []Arg{{Name: "URL", Type: ArgURL}},
+			Cooldown: Cooldown{Scope: PerUser, Burst: 3, Per: 30 * time.Second}, Run: playYoutubeLink},
+		{Name: "play", Category: categoryMusic, Usage: ".play <URL>", Description: "Plays an audio file from a link",
+			Examples: []string{".play https://example.com/song.mp3"},
+			Args:     []Arg{{Name: "URL", Type: ArgURL}},
+			Cooldown: Cooldown{Scope: PerUser, Burst: 3, Per: 30 * time.Second}, Run: playAudioLink},
+		{Name: "library", Aliases: []string{"lib"}, Category: categoryMusic, Usage: ".lib list|play|analyze",
+			Description: "Browses and plays the music library",
+			Examples:    []string{".lib list 1", ".lib play 12"},
+			Subcommands: []*Command{
+				{Name: "list", Usage: ".lib list [page]", Description: "Lists the library",
+					Args: []Arg{{Name: "page", Type: ArgInt, Optional: true, Min: 1}}, Run: listLibrary},
+				{Name: "play", Usage: ".lib play <id>", Description: "Plays a library track",
+					Args: []Arg{{Name: "id", Type: ArgInt, Min: 1, Complete: completeLibraryTrack}}, Run: playLibraryTrack},
+				{Name: "analyze", Usage: ".lib analyze", Description: "Measures the loudness of every track",
+					Level:    PermAdmin,
+					Cooldown: Cooldown{Scope: PerGuild, Burst: 1, Per: 5 * time.Minute}, Run: analyzeLibrary},
+			}},
+		{Name: "skip", Aliases: []string{"next"}, Category: categoryMusic, Usage: ".skip",
+			Description: "Skips to the next song in the queue", Level: PermDJ, Run: nextSong},
+		{Name: "stop", Category: categoryMusic, Usage: ".stop", Description: "Stops the music", Level: PermDJ, Run: stopMusic},
+		{Name: "np", Category: categoryMusic, Usage: ".np", Description: "Shows the song playing now", Run: showNowPlaying},
+		{Name: "queue", Aliases: []string{"q"}, Category: categoryMusic, Usage: ".queue", Description: "Lists the songs coming up", Run: showQueue},
+		{Name: "history", Category: categoryMusic, Usage: ".history", Description: "Lists the songs that played here lately", Run: showHistory},
+		{Name: "lyrics", Category: categoryMusic, Usage: ".lyrics [lib id]", Description: "Shows the lyrics of a track",
+			Examples: []string{".lyrics", ".lyrics 12", ".lyrics karaoke"},
+			Args:     []Arg{{Name: "lib id", Type: ArgInt, Optional: true, Min: 1, Complete: completeLibraryTrack}},
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 2, Per: 10 * time.Second}, Run: showLyrics,
+			Subcommands: []*Command{
+				{Name: "karaoke", Usage: ".lyrics karaoke", Description: "Sings along to the current song",
+					Cooldown: Cooldown{Scope: PerGuild, Burst: 1, Per: 30 * time.Second}, Run: startKaraoke},
+			}},
+		{Name: "waveform", Category: categoryMusic, Usage: ".waveform [lib id]", Description: "Draws the waveform of a track",
+			Args:     []Arg{{Name: "lib id", Type: ArgInt, Optional: true, Min: 1, Complete: completeLibraryTrack}},
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 2, Per: 20 * time.Second}, Run: showWaveform},
+		{Name: "spectrogram", Category: categoryMusic, Usage: ".spectrogram [lib id]", Description: "Draws the spectrogram of a track",
+			Args:     []Arg{{Name: "lib id", Type: ArgInt, Optional: true, Min: 1, Complete: completeLibraryTrack}},
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 2, Per: 20 * time.Second}, Run: showSpectrogram},
+		{Name: "crossfade", Category: categoryMusic, Usage: ".crossfade [seconds]", Description: "Shows or sets the crossfade between songs",
+			Examples: []string{".crossfade 5", ".crossfade 0"},
+			Args:     []Arg{{Name: "seconds", Type: ArgInt, Optional: true, Max: int(maxCrossfade / time.Second)}}, Run: setCrossfade},
+		{Name: "musicconfig", Category: categoryMusic, Usage: ".musicconfig [length|peruser|queue|fair]",
+			Description: "Shows or changes the queue limits", Run: showMusicConfig,
+			Examples: []string{".musicconfig length 10", ".musicconfig fair on"},
+			Subcommands: []*Command{
+				{Name: "length", Usage: ".musicconfig length <minutes>", Description: "Sets the longest song allowed, 0 is unlimited",
+					Level: PermAdmin, Args: []Arg{{Name: "minutes", Type: ArgInt, Max: 600}}, Run: setMaxSongLength},
+				{Name: "peruser", Usage: ".musicconfig peruser <songs>", Description: "Sets how many songs one user can queue, 0 is unlimited",
+					Level: PermAdmin, Args: []Arg{{Name: "songs", Type: ArgInt, Max: 100}}, Run: setMaxSongsPerUser},
+				{Name: "queue", Usage: ".musicconfig queue <songs>", Description: "Sets how long the queue can get, 0 is unlimited",
+					Level: PermAdmin, Args: []Arg{{Name: "songs", Type: ArgInt, Max: 1000}}, Run: setMaxQueueLength},
+				{Name: "fair", Usage: ".musicconfig fair on|off", Description: "Lets requesters take turns",
+					Level: PermAdmin, Args: []Arg{{Name: "state", Type: ArgBool}}, Run: setFairQueue},
+			}},
+		{Name: "quiz", Category: categoryMusic, Usage: ".quiz start|stop", Description: "Plays a music quiz",
+			Examples: []string{".quiz start", ".quiz start 5 rock"},
+			Subcommands: []*Command{
+				{Name: "start", Usage: ".quiz start [rounds] [category]", Description: "Starts a quiz",
+					Args: []Arg{
+						{Name: "rounds", Type: ArgInt, Optional: true, Min: 1, Max: quizMaxRounds},
+						{Name: "category", Optional: true, Complete: completeLibraryFolder},
+					},
+					Cooldown: Cooldown{Scope: PerGuild, Burst: 1, Per: 2 * time.Minute}, Run: startQuiz},
+				{Name: "stop", Usage: ".quiz stop", Description: "Ends the quiz", Level: PermDJ, Run: stopQuiz},
+			}},
+
+		// Soundboard
+		{Name: "bruh", Category: categorySoundboard, Usage: ".bruh", Description: "Plays the bruh sound",
+			Cooldown: Cooldown{Scope: PerGuild, Burst: 3, Per: 10 * time.Second}, Run: playBruhSound},
+		{Name: "stal", Category: categorySoundboard, Usage: ".stal", Description: "Plays the stal music",
+			Cooldown: Cooldown{Scope: PerGuild, Burst: 3, Per: 10 * time.Second}, Run: playStalMusic},
+		{Name: "say", Category: categorySoundboard, Usage: ".say <text>", Description: "Says text in voice",
+			Examples: []string{".say hello there"},
+			Args:     []Arg{{Name: "text", Rest: true}},
+			Cooldown: Cooldown{Scope: PerUser, Burst: 2, Per: 10 * time.Second}, Run: sayText},
+		{Name: "tts", Category: categorySoundboard, Usage: ".tts <lang> <text>", Description: "Says text in voice in another language",
+			Examples: []string{".tts ru привет"},
+			Args:     []Arg{{Name: "lang"}, {Name: "text", Rest: true}},
+			Cooldown: Cooldown{Scope: PerUser, Burst: 2, Per: 10 * time.Second}, Run: sayTextInLanguage},
+		{Name: "ttsconfig", Category: categorySoundboard, Usage: ".ttsconfig [lang|voice|limit|channel]",
+			Description: "Shows or changes text-to-speech settings", Run: showTTSSettings,
+			Examples: []string{".ttsconfig lang ru", ".ttsconfig channel off"},
+			Subcommands: []*Command{
+				{Name: "lang", Usage: ".ttsconfig lang <language>", Description: "Sets the default language",
+					Level: PermModerator, Args: []Arg{{Name: "language"}}, Run: setTTSLanguage},
+				{Name: "voice", Usage: ".ttsconfig voice [voice]", Description: "Sets the voice, none goes back to the default",
+					Level: PermModerator, Args: []Arg{{Name: "voice", Optional: true}}, Run: setTTSVoice},
+				{Name: "limit", Usage: ".ttsconfig limit <characters>", Description: "Sets how much text is read at most",
+					Level: PermModerator, Args: []Arg{{Name: "limit", Type: ArgInt, Min: 1, Max: ttsMaxLimit}}, Run: setTTSLimit},
+				{Name: "channel", Usage: ".ttsconfig channel [off]", Description: "Reads this channel out for muted members",
+					Level: PermModerator, Args: []Arg{{Name: "off", Optional: true, Choices: []string{"off"}}}, Run: setTTSReadChannel},
+			}},
+		{Name: "recording", Category: categorySoundboard, Usage: ".recording [on|off]",
+			Description: "Shows or turns rolling voice recording on or off", Run: showRecording,
+			Subcommands: []*Command{
+				{Name: "on", Usage: ".recording on", Description: "Keeps the last minute of voice for .clip", Level: PermAdmin, Run: toggleRecording},
+				{Name: "off", Usage: ".recording off", Description: "Stops recording voice", Level: PermAdmin, Run: toggleRecording},
+			}},
+		{Name: "clip", Category: categorySoundboard, Usage: ".clip [duration] [save <name>]", Description: "Clips the last seconds of voice",
+			Examples: []string{".clip", ".clip 15s", ".clip 20 save bruh2"},
+			Args: []Arg{
+				{Name: "duration", Type: ArgDuration, Optional: true},
+				{Name: "save", Optional: true, Choices: []string{"save"}},
+				{Name: "name", Optional: true, Complete: completeSoundClip},
 			},
-		},
-		Image: &discordgo.MessageEmbedImage{
-			URL: imageMeURL,
-		},
-		Thumbnail: &discordgo.MessageEmbedThumbnail{
-			URL: imageMeURL,
-		},
-		Timestamp: time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-		Title:     "I am an Embed",
+			Cooldown: Cooldown{Scope: PerGuild, Burst: 1, Per: 10 * time.Second}, Run: clipThat},
+		{Name: "schedule", Category: categorySoundboard, Usage: ".schedule add|list|remove", Description: "Plays sounds on a schedule",
+			Examples: []string{".schedule add 0 23 * * * Europe/Moscow #lounge goodnight leave", ".schedule remove 2"},
+			Subcommands: []*Command{
+				{Name: "add", Usage: ".schedule add <min> <hour> <day> <month> <weekday> <timezone> <#voice channel> <clip|lib id|folder> [leave]",
+					Description: "Schedules a sound", Level: PermAdmin,
+					Args: []Arg{
+						{Name: "minute"}, {Name: "hour"}, {Name: "day"}, {Name: "month"}, {Name: "weekday"},
+						{Name: "timezone"},
+						{Name: "voice channel", Type: ArgChannel},
+						{Name: "target", Complete: completeScheduleTarget},
+						{Name: "leave", Optional: true, Choices: []string{"leave"}},
+					}, Run: addSchedule},
+				{Name: "list", Usage: ".schedule list", Description: "Lists scheduled sounds", Run: listSchedules},
+				{Name: "remove", Usage: ".schedule remove <id>", Description: "Removes a scheduled sound", Level: PermAdmin,
+					Args: []Arg{{Name: "id", Type: ArgInt, Min: 1}}, Run: removeSchedule},
+			}},
+
+		// Memes
+		{Name: "text", Category: categoryMemes, Usage: ".text", Description: "Shows an example embed",
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 3, Per: 10 * time.Second}, Run: getText},
+		{Name: "ping", Category: categoryMemes, Usage: ".ping", Description: "Pong!",
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 3, Per: 10 * time.Second}, Run: pong},
+		{Name: "pong", Category: categoryMemes, Usage: ".pong", Description: "Ping!",
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 3, Per: 10 * time.Second}, Run: ping},
+		{Name: "flex", Category: categoryMemes, Usage: ".flex", Description: "Flexes",
+			Cooldown: Cooldown{Scope: PerChannel, Burst: 3, Per: 10 * time.Second}, Run: flex},
+
+		// Finance
+		{Name: "balance", Category: categoryFinance, Usage: ".balance [@user]", Description: "Shows DMasik coins",
+			Args: []Arg{{Name: "user", Type: ArgUser, Optional: true}}, Run: showBalance},
+
+		// Tools
+		{Name: "help", Category: categoryTools, Usage: ".help [page|command]", Description: "Lists commands or explains one",
+			Examples: []string{".help", ".help 2", ".help lib play"},
+			Args:     []Arg{{Name: "command", Optional: true, Rest: true}}, Run: showHelp},
+		{Name: "voicetime", Category: categoryTools, Usage: ".voicetime [@user]", Description: "Shows time spent in voice",
+			Args: []Arg{{Name: "user", Type: ArgUser, Optional: true}}, Run: showVoiceTime},
+		{Name: "voicetop", Category: categoryTools, Usage: ".voicetop", Description: "Shows who spent the most time in voice this week", Run: showVoiceLeaderboard},
+		{Name: "afk", Category: categoryTools, Usage: ".afk", Description: "Shows who is idle or muted in voice", Run: showAFKReport},
+		{Name: "prefix", Category: categoryTools, Usage: ".prefix [set|reset]", Description: "Shows or changes what commands start with",
+			Examples: []string{".prefix", ".prefix set ! dm!", ".prefix reset"}, Run: showPrefixes,
+			Subcommands: []*Command{
+				{Name: "set", Usage: ".prefix set <prefixes...>", Description: "Sets one or more prefixes, the mention always works too",
+					Level: PermAdmin, Args: []Arg{{Name: "prefixes", Rest: true}}, Run: setPrefixes},
+				{Name: "reset", Usage: ".prefix reset", Description: "Goes back to the . prefix", Level: PermAdmin, Run: resetPrefixes},
+			}},
+		{Name: "tag", Category: categoryTools, Usage: ".tag create|edit|delete|list|info", Description: "Runs or manages this server's own commands",
+			Examples: []string{".tag create rules Be nice, {user}!", ".tag create --embed logo", ".rules", ".tag list"},
+			Args:     []Arg{{Name: "name", Complete: completeTag}, {Name: "args", Optional: true, Rest: true}}, Run: invokeTag,
+			Subcommands: []*Command{
+				{Name: "create", Usage: ".tag create [--embed] <name> [text]", Description: "Makes a tag, files attached to the message go with it",
+					Level: PermModerator, Args: []Arg{{Name: "name"}, {Name: "text", Optional: true, Rest: true}},
+					Flags: []Arg{{Name: "embed", Type: ArgBool}}, Run: createTag},
+				{Name: "edit", Usage: ".tag edit [--embed] <name> [text]", Description: "Changes a tag's text, and its files if new ones are attached",
+					Level: PermModerator, Args: []Arg{{Name: "name", Complete: completeTag}, {Name: "text", Optional: true, Rest: true}},
+					Flags: []Arg{{Name: "embed", Type: ArgBool}}, Run: editTag},
+				{Name: "delete", Usage: ".tag delete <name>", Description: "Deletes a tag",
+					Level: PermModerator, Args: []Arg{{Name: "name", Complete: completeTag}}, Run: deleteTag},
+				{Name: "list", Usage: ".tag list", Description: "Lists this server's tags", Run: listTags},
+				{Name: "info", Usage: ".tag info <name>", Description: "Shows who made a tag and how often it's used",
+					Args: []Arg{{Name: "name", Complete: completeTag}}, Run: showTagInfo},
+			}},
+		{Name: "autoresponse", Aliases: []string{"ar"}, Category: categoryMemes, Usage: ".autoresponse add|list|remove", Description: "Manages what the bot answers to chat messages",
+			Examples: []string{".autoresponse add exact да text П-ворд", ".autoresponse add word bruh sound bruh --chance 50 --cooldown 1m",
+				".autoresponse add contains pizza reaction 🍕 --channel #food", ".autoresponse remove 1"}, Run: listAutoResponses,
+			Subcommands: []*Command{
+				{Name: "add", Usage: ".autoresponse add [--chance percent] [--cooldown duration] [--channel #channel] <trigger> <pattern> <response> [value]", Description: "Adds a response, an image can be attached instead of linked",
+					Level: PermModerator, Args: []Arg{{Name: "trigger", Choices: autoResponseTriggers}, {Name: "pattern"},
+						{Name: "response", Choices: autoResponseResponses},
+						{Name: "value", Optional: true, Rest: true, Complete: completeSoundClip}},
+					Flags: []Arg{{Name: "chance", Type: ArgInt, Min: 1, Max: 100}, {Name: "cooldown", Type: ArgDuration}, {Name: "channel", Type: ArgChannel}}, Run: addAutoResponse},
+				{Name: "list", Usage: ".autoresponse list", Description: "Lists this server's auto responses", Run: listAutoResponses},
+				{Name: "remove", Usage: ".autoresponse remove <id>", Description: "Removes an auto response",
+					Level: PermModerator, Args: []Arg{{Name: "id", Type: ArgInt, Min: 1}}, Run: removeAutoResponse},
+			}},
+		{Name: "perms", Category: categoryTools, Usage: ".perms [role|user]", Description: "Shows or changes who may run which commands",
+			Examples: []string{".perms", ".perms role @Radio dj", ".perms user @someone moderator"}, Run: showPermissions,
+			Subcommands: []*Command{
+				{Name: "role", Usage: ".perms role <@role> <level>", Description: "Gives a role a level, everyone takes it away",
+					Level: PermAdmin, Args: []Arg{{Name: "role", Type: ArgRole}, {Name: "level", Choices: assignableLevels}}, Run: setRoleLevel},
+				{Name: "user", Usage: ".perms user <@user> <level>", Description: "Gives a member a level, everyone takes it away",
+					Level: PermAdmin, Args: []Arg{{Name: "user", Type: ArgUser}, {Name: "level", Choices: assignableLevels}}, Run: setUserLevel},
+			}},
+		{Name: "theme", Category: categoryTools, Usage: ".theme [color|footer|reset]", Description: "Shows or changes how the bot's embeds look here",
+			Examples: []string{".theme", ".theme color #ff8800", ".theme footer Radio DMasik", ".theme footer"}, Run: showTheme,
+			Subcommands: []*Command{
+				{Name: "color", Usage: ".theme color <#hex>", Description: "Sets the accent color of embeds",
+					Level: PermAdmin, Args: []Arg{{Name: "color"}}, Run: setThemeColor},
+				{Name: "footer", Usage: ".theme footer [text]", Description: "Sets the footer of embeds, without text there is none",
+					Level: PermAdmin, Args: []Arg{{Name: "text", Optional: true, Rest: true}}, Run: setThemeFooter},
+				{Name: "reset", Usage: ".theme reset", Description: "Goes back to the default look", Level: PermAdmin, Run: resetTheme},
+			}},
+		{Name: "lang", Category: categoryTools, Usage: ".lang [language]", Description: "Shows or, for admins, changes the language of replies here",
+			Examples: []string{".lang", ".lang ru"}, Args: []Arg{{Name: "language", Optional: true, Complete: completeLanguage}}, Run: setLanguage},
+		{Name: "persona", Category: categoryTools, Usage: ".persona [persona]", Description: "Shows or, for admins, changes how the bot talks here",
+			Examples: []string{".persona", ".persona neutral", ".persona gopnik"}, Args: []Arg{{Name: "persona", Optional: true, Complete: completePersona}}, Run: setPersona},
+		{Name: "metrics", Category: categoryTools, Usage: ".metrics", Description: "Shows how often and how fast commands ran",
+			Level: PermOwner, Run: showMetrics},
+		{Name: "slash", Category: categoryTools, Usage: ".slash sync [guild|global]", Description: "Manages the slash commands",
+			Subcommands: []*Command{
+				{Name: "sync", Usage: ".slash sync [guild|global]", Description: "Registers the slash commands here, or everywhere for the bot owner",
+					Level: PermAdmin, Args: []Arg{{Name: "scope", Optional: true, Choices: []string{"guild", "global"}}}, Run: syncSlashCommands},
+			}},
+	} {
+		registerCommand(cmd)
 	}
-)
+}
 
 func main() {
-	var discordToken string
+	// .env is optional, what it sets counts as environment.
+	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Error loading .env,", err)
+	}
+	loaded, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if loaded != nil && strings.Join(loaded.Args, " ") == "config check" {
+		os.Exit(checkConfig(loaded, err, os.Stdout))
+	}
+	if err != nil {
+		log.Fatal("Error loading config, ", err)
+	}
+	if len(loaded.Args) > 0 {
+		log.Fatal("Unknown command, ", strings.Join(loaded.Args, " "))
+	}
+	loaded.apply()
 
-	discordToken = getDiscordToken()
-	dg, err := discordgo.New("Bot " + discordToken)
+	err = loadData("tts", &ttsGuilds)
+	if err != nil {
+		log.Fatal("Error loading TTS settings,", err)
+	}
+	err = loadData("recording", &recordingGuilds)
+	if err != nil {
+		log.Fatal("Error loading recording settings,", err)
+	}
+	err = loadData("voice", &voiceGuilds)
+	if err != nil {
+		log.Fatal("Error loading voice activity,", err)
+	}
+	err = loadData("wallet", &wallets)
+	if err != nil {
+		log.Fatal("Error loading wallets,", err)
+	}
+	err = loadData("schedules", &schedules)
+	if err != nil {
+		log.Fatal("Error loading schedules,", err)
+	}
+	err = loadData("library", &libraryIndex)
+	if err != nil {
+		log.Fatal("Error loading library index,", err)
+	}
+	err = loadData("crossfade", &crossfadeGuilds)
+	if err != nil {
+		log.Fatal("Error loading crossfade settings,", err)
+	}
+	err = loadData("musicconfig", &musicPolicies)
+	if err != nil {
+		log.Fatal("Error loading music settings,", err)
+	}
+	err = loadData("prefixes", &guildPrefixes)
+	if err != nil {
+		log.Fatal("Error loading prefixes,", err)
+	}
+	err = loadData("perms", &guildPermissions)
+	if err != nil {
+		log.Fatal("Error loading permissions,", err)
+	}
+	err = loadData("tags", &guildTags)
+	if err != nil {
+		log.Fatal("Error loading tags,", err)
+	}
+	err = loadData("autoresponses", &guildAutoResponses)
+	if err != nil {
+		log.Fatal("Error loading auto responses,", err)
+	}
+	err = migrateSoundResponses()
+	if err != nil {
+		log.Println("Error updating sound auto responses,", err)
+	}
+	err = loadData("themes", &guildThemes)
+	if err != nil {
+		log.Fatal("Error loading themes,", err)
+	}
+	err = loadData("locales", &guildLocales)
+	if err != nil {
+		log.Fatal("Error loading languages,", err)
+	}
+	err = loadCatalogs()
+	if err != nil {
+		log.Fatal("Error loading message catalogs,", err)
+	}
+	dg, err = discordgo.New("Bot " + config.Token)
 	if err != nil {
 		log.Fatal("Error creating Discord session,", err)
 	}
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent |
+		discordgo.IntentsGuildVoiceStates | discordgo.IntentsGuildMessageReactions
 	dg.AddHandler(discordMessageHandler)
+	dg.AddHandler(interactionCreateHandler)
+	dg.AddHandler(pageReactionHandler)
+	dg.AddHandler(voiceStateUpdateHandler)
+	dg.AddHandler(guildCreateVoiceHandler)
 	err = dg.Open()
 	if err != nil {
 		log.Println("Error opening connection,", err)
 		return
 	}
-	stopChannel = make(chan bool)
+	startScheduler(dg)
+	startVoiceFlusher()
 
 	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
@@ -119,19 +395,7 @@ func main() {
 	<-sc
 
 	dg.Close()
-}
-
-func getDiscordToken() string {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	discordToken := os.Getenv("DISCORD_TOKEN")
-	if discordToken == "" {
-		log.Fatalf("Error, DISCORD_TOKEN in .env not found")
-	}
-	return discordToken
+	flushVoiceActivity()
 }
 
 func discordMessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -141,165 +405,187 @@ func discordMessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
-	if strings.ToLower(m.Content) == "да" {
-		s.ChannelMessageSend(m.ChannelID, "П-ворд")
-	}
-	msgIsCommand, command = isCommand(m.Content)
+	msgIsCommand, command = isCommand(m.Content, prefixesFor(m.GuildID), s.State.User.ID)
 
-	if msgIsCommand {
-		commandArgs = strings.Split(command, " ")
-
-		if function, ok := commands[commandArgs[0]]; ok {
-			log.Println("Executing {", commandArgs[0], "} command")
-			function(s, m)
-		} else {
-			log.Println("{", commandArgs[0], "} not in map[string]func")
-			s.ChannelMessageSend(m.ChannelID, "oWu sowwy but I do not posess such a command, if you would be so kind to contribute to github.com/defolt17/DMasik by adding it or provodong desirable functional.")
+	if msgIsCommand && strings.TrimSpace(command) != "" {
+		ctx := messageContext(s, m)
+		if !runCommand(ctx, command) && !runTag(ctx, command) {
+			replyUnknownCommand(s, m, command)
 		}
 	} else {
-
+		readForMutedMember(s, m)
+		quizGuess(m)
+		autoRespond(s, m)
 	}
 
 }
 
-func isCommand(str string) (bool, string) {
-	for i, n := range discordPrefix {
-		if n != rune(str[i]) {
-			return false, ""
-		}
-	}
-	log.Println("[", str, "] starts with [", discordPrefix, "]")
-	return true, str[len(discordPrefix):]
-}
-
-func getText(s *discordgo.Session, m *discordgo.MessageCreate) {
-	s.ChannelMessageSendEmbed(m.ChannelID, embedExample)
-	s.ChannelMessageSend(m.ChannelID, commandArgs[0])
+func getText(ctx *Context) {
+	ctx.ReplyEmbed(newEmbed(ctx.T("demo.title")).
+		Description(ctx.T("demo.description")).
+		InlineField(ctx.T("demo.field"), ctx.T("demo.value")).
+		Image(imageMeURL).
+		Thumbnail(imageMeURL).
+		Timestamp(time.Now()))
+	ctx.Reply(ctx.Name)
 }
 
-func ping(s *discordgo.Session, m *discordgo.MessageCreate) {
-	s.ChannelMessageSend(m.ChannelID, "Ping!")
+func ping(ctx *Context) {
+	ctx.Say("ping.ping")
 }
 
-func pong(s *discordgo.Session, m *discordgo.MessageCreate) {
-	s.ChannelMessageSend(m.ChannelID, "Pong!")
+func pong(ctx *Context) {
+	ctx.Say("ping.pong")
 }
 
-func connectToVC(s *discordgo.Session, m *discordgo.MessageCreate) {
-	channel, err := s.State.Channel(m.ChannelID)
-	if err != nil {
-		fmt.Println(err)
+func connectToVC(ctx *Context) {
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
 	}
-	guild, err := s.State.Guild(channel.GuildID)
+	if voiceChannel == "" {
+		ctx.Say("voice.join_first")
+		return
+	}
+	voice, err := connectToVoiceChannel(ctx.Session, ctx.GuildID, voiceChannel)
 	if err != nil {
-		fmt.Println(err)
+		log.Println("Error joining voice,", err)
+		ctx.Say("voice.join_failed")
+		return
 	}
-	voiceChannel := findVoiceChannelID(guild, m)
-	for _, vs := range guild.VoiceStates {
-		log.Println(vs.UserID, vs.ChannelID, m.Author.ID, m.Author.Username)
+	voiceConnections = append(voiceConnections, voice)
+	announceRecording(ctx)
+}
 
+// authorVoiceChannel returns the voice channel the author is in, "" when
+// they aren't in one. It is false when the guild isn't known, the author
+// has been told then.
+func authorVoiceChannel(ctx *Context) (string, bool) {
+	guild, err := ctx.Session.State.Guild(ctx.GuildID)
+	if err != nil {
+		log.Println("Error getting guild", ctx.GuildID, err)
+		ctx.Say("voice.no_guild")
+		return "", false
 	}
-	voiceConnections = append(voiceConnections, connectToVoiceChannel(s, channel.GuildID, voiceChannel))
+	return findVoiceChannelID(guild, ctx.Author.ID), true
 }
 
-func findVoiceChannelID(guild *discordgo.Guild, message *discordgo.MessageCreate) string {
+func findVoiceChannelID(guild *discordgo.Guild, userID string) string {
 	var channelID string
 
 	for _, vs := range guild.VoiceStates {
-		if vs.UserID == message.Author.ID {
+		if vs.UserID == userID {
 			channelID = vs.ChannelID
 		}
 	}
 	return channelID
 }
 
-func connectToVoiceChannel(bot *discordgo.Session, guild string, channel string) Voice {
-	vs, err := bot.ChannelVoiceJoin(guild, channel, false, true)
+func isGuildAdmin(s *discordgo.Session, channelID string, userID string) bool {
+	permissions, err := s.State.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0
+}
+
+// connectToVoiceChannel joins channel, replacing the guild's old
+// connection. Only a successful join should go into voiceConnections.
+func connectToVoiceChannel(bot *discordgo.Session, guild string, channel string) (Voice, error) {
+	recording := guildRecordingEnabled(guild)
+	vs, err := bot.ChannelVoiceJoin(guild, channel, false, !recording)
 
-	checkForDoubleVoiceConnection(guild, channel)
+	dropVoice(guild)
 
 	if err != nil {
-		fmt.Println(err)
+		return Voice{}, err
 	}
-	return Voice{
+	voice := Voice{
 		VoiceConnection: vs,
 		Channel:         channel,
 		Guild:           guild,
-		PlayerStatus:    IS_NOT_PLAYING,
+		Mixer:           newMixer(vs),
 	}
-
+	voice.Mixer.SetCrossfade(guildCrossfade(guild))
+	if recording {
+		voice.Recorder = newRecorder(vs)
+	}
+	return voice, nil
 }
 
-func checkForDoubleVoiceConnection(guild string, channel string) {
-	for index, voice := range voiceConnections {
-		if voice.Guild == guild {
-			voiceConnections = append(voiceConnections[:index], voiceConnections[index+1:]...)
+// dropVoice stops the guild's mixer and recorder and takes its entries out
+// of voiceConnections, returning them.
+func dropVoice(guild string) []Voice {
+	var dropped []Voice
+	kept := make([]Voice, 0, len(voiceConnections))
+	for _, voice := range voiceConnections {
+		if voice.Guild != guild {
+			kept = append(kept, voice)
+			continue
 		}
+		if voice.Mixer != nil {
+			voice.Mixer.Close()
+		}
+		if voice.Recorder != nil {
+			voice.Recorder.Close()
+		}
+		dropped = append(dropped, voice)
 	}
+	voiceConnections = kept
+	return dropped
 }
 
-func disconnectFromVoiceChannel(s *discordgo.Session, m *discordgo.MessageCreate) {
-	channel, err := s.State.Channel(m.ChannelID)
-	if err != nil {
-		fmt.Println(err)
+func disconnectFromVoiceChannel(ctx *Context) {
+	if err := disconnectFromGuild(ctx.GuildID); err != nil {
+		log.Println("Error leaving voice,", err)
 	}
+}
 
-	for index, voice := range voiceConnections {
-		if voice.Guild == channel.GuildID {
-			err := voice.VoiceConnection.Disconnect()
-			if err != nil {
-				log.Fatalln(err)
-			}
-			stopChannel <- true
-			voiceConnections = append(voiceConnections[:index], voiceConnections[index+1:]...)
+// disconnectFromGuild leaves voice in guild. The connection is dropped
+// even when Discord returns an error, which is returned for logging.
+func disconnectFromGuild(guild string) error {
+	var err error
+	for _, voice := range dropVoice(guild) {
+		if voice.VoiceConnection == nil {
+			continue
+		}
+		if e := voice.VoiceConnection.Disconnect(); e != nil {
+			err = e
 		}
 	}
+	return err
 }
 
-func playBruhSound(s *discordgo.Session, m *discordgo.MessageCreate) {
-	channel, err := s.State.Channel(m.ChannelID)
-	if err != nil {
-		fmt.Println(err)
-	}
-	guild, err := s.State.Guild(channel.GuildID)
-	if err != nil {
-		fmt.Println(err)
+func playBruhSound(ctx *Context) {
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
 	}
-	voiceChannel := findVoiceChannelID(guild, m)
-	go playAudioFile(bruhSoundPath, channel.GuildID, voiceChannel, "web")
+	requestSong(ctx, bruhSoundPath, ctx.GuildID, voiceChannel, "file")
 }
 
-func playStalMusic(s *discordgo.Session, m *discordgo.MessageCreate) {
-	channel, err := s.State.Channel(m.ChannelID)
-	if err != nil {
-		fmt.Println(err)
-	}
-	guild, err := s.State.Guild(channel.GuildID)
-	if err != nil {
-		fmt.Println(err)
+func playStalMusic(ctx *Context) {
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
 	}
-	voiceChannel := findVoiceChannelID(guild, m)
-	go playAudioFile(stalMusicPath, channel.GuildID, voiceChannel, "web")
+	requestSong(ctx, stalMusicPath, ctx.GuildID, voiceChannel, "file")
 }
 
-func playAudioFile(file string, guild string, channel string, linkType string) {
-	voiceConnection, index := findVoiceConnection(guild, channel)
-
-	switch voiceConnection.PlayerStatus {
-	case IS_NOT_PLAYING:
-		voiceConnections[index].PlayerStatus = IS_PLAYING
-
-		dgvoice.PlayAudioFile(voiceConnection.VoiceConnection, file, stopChannel)
+func playAudioFile(song Song) {
+	voiceConnection, _ := findVoiceConnection(song.Guild, song.Channel)
+	if voiceConnection.Mixer == nil {
+		log.Println("No voice connection in guild", song.Guild)
+		return
+	}
 
-		voiceConnections[index].PlayerStatus = IS_NOT_PLAYING
-	case IS_PLAYING:
-		addSong(Song{
-			Link:    file,
-			Type:    linkType,
-			Guild:   guild,
-			Channel: channel,
-		})
+	if !startPlaying(song.Guild) {
+		addSong(song)
+		return
 	}
+	defer stopPlaying(song.Guild)
+	startQueue(voiceConnection.Mixer, song)
 }
 
 func findVoiceConnection(guild string, channel string) (Voice, int) {
@@ -315,43 +601,98 @@ func findVoiceConnection(guild string, channel string) (Voice, int) {
 
 }
 
-func addSong(song Song) {
-	queue = append(queue, song)
+func setCurrentSong(song Song) {
+	nowPlayingMutex.Lock()
+	nowPlaying[song.Guild] = song
+	nowPlayingMutex.Unlock()
+	recordSong(song)
+}
+
+// clearCurrentSong forgets the guild's current song unless something else
+// started playing in the meantime.
+func clearCurrentSong(guild string, link string) {
+	nowPlayingMutex.Lock()
+	if nowPlaying[guild].Link == link {
+		delete(nowPlaying, guild)
+	}
+	nowPlayingMutex.Unlock()
 }
 
-func stopMusic(s *discordgo.Session, m *discordgo.MessageCreate) {
-	stopChannel <- true
+func currentSong(guild string) (Song, bool) {
+	nowPlayingMutex.Lock()
+	defer nowPlayingMutex.Unlock()
+	song, ok := nowPlaying[guild]
+	return song, ok
 }
 
-func playYoutubeLink(s *discordgo.Session, m *discordgo.MessageCreate) {
+func addSong(song Song) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if !guildMusicPolicy(song.Guild).Fair {
+		queue = append(queue, song)
+		return
+	}
+	at := fairInsert(queue, song)
+	queue = append(queue, Song{})
+	copy(queue[at+1:], queue[at:])
+	queue[at] = song
+}
 
-	if len(commandArgs) < 2 {
-		s.ChannelMessageSend(m.ChannelID, "The [ .yt ] command needs argument: .yt <URL>")
+// playAudioSnippet plays part of a file right away, cutting off whatever
+// music was playing instead of queueing behind it.
+func playAudioSnippet(guild string, file string, start time.Duration, length time.Duration) {
+	voiceConnection, _ := findVoiceConnection(guild, "")
+	if voiceConnection.Mixer == nil {
+		log.Println("No voice connection in guild", guild)
 		return
 	}
-	channel, err := s.State.Channel(m.ChannelID)
+	src, err := newFFmpegSource(file, ffmpegOptions{Start: start, Duration: length, Gain: normalizationGain(file)})
 	if err != nil {
-		fmt.Println(err)
+		log.Println("Error starting ffmpeg,", err)
+		return
 	}
-	guild, err := s.State.Guild(channel.GuildID)
-	if err != nil {
-		fmt.Println(err)
+	voiceConnection.Mixer.PlayMusic(src)
+}
+
+// pauseMusic holds or resumes the guild's music, overlays keep playing.
+func pauseMusic(guild string, paused bool) {
+	voiceConnection, _ := findVoiceConnection(guild, "")
+	if voiceConnection.Mixer != nil {
+		voiceConnection.Mixer.SetPaused(paused)
 	}
+}
 
-	voiceChannel := findVoiceChannelID(guild, m)
-	audioURL, err := getYoutubeAudioLink(commandArgs[1])
+func stopMusicInGuild(guild string) {
+	voiceConnection, _ := findVoiceConnection(guild, "")
+	if voiceConnection.Mixer != nil {
+		voiceConnection.Mixer.StopMusic()
+	}
+}
+
+func stopMusic(ctx *Context) {
+	stopMusicInGuild(ctx.GuildID)
+}
+
+func playYoutubeLink(ctx *Context) {
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
+	}
+	audioURL, err := getYoutubeAudioLink(ctx.Args[0])
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "uWo sowwy but I couldn't extract audio track from this video")
+		log.Println("Error getting audio of", ctx.Args[0], err)
+		ctx.Say("play.extract_failed")
+		return
 	}
 
-	go playAudioFile(audioURL, channel.GuildID, voiceChannel, "web")
+	requestSong(ctx, audioURL, ctx.GuildID, voiceChannel, "web")
 }
 
 func getYoutubeAudioLink(URL string) (string, error) {
 
 	video, err := ytdl.GetVideoInfo(context.Background(), URL)
 	if err != nil {
-		log.Fatalln(err)
+		return "", err
 	}
 	client := ytdl.Client{}
 
@@ -359,141 +700,89 @@ func getYoutubeAudioLink(URL string) (string, error) {
 		if format.AudioEncoding == "opus" || format.AudioEncoding == "aac" || format.AudioEncoding == "vorbis" {
 			data, err := client.GetDownloadURL(context.Background(), video, format)
 			if err != nil {
-				fmt.Println(err)
+				return "", err
 			}
-			url := data.String()
-			return url, nil
+			return data.String(), nil
 		}
 	}
 	return "", errors.New("Coudn't extract audio track from given video")
 }
 
-func playAudioLink(s *discordgo.Session, m *discordgo.MessageCreate) {
-	channel, err := s.State.Channel(m.ChannelID)
-	if err != nil {
-		fmt.Println(err)
-	}
-	guild, err := s.State.Guild(channel.GuildID)
-	if err != nil {
-		fmt.Println(err)
+func playAudioLink(ctx *Context) {
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
 	}
-	voiceChannel := findVoiceChannelID(guild, m)
 
-	s.ChannelMessageSend(m.ChannelID, commandArgs[1])
+	ctx.Reply(ctx.Args[0])
 
-	go playAudioFile(commandArgs[1], channel.GuildID, voiceChannel, "web")
+	requestSong(ctx, ctx.Args[0], ctx.GuildID, voiceChannel, "web")
 }
 
-func playLibraryMusic(s *discordgo.Session, m *discordgo.MessageCreate) {
-	var musicArr []string
-	var musicNameArr []string
-	var musicStrList string
-	var c int = 0
-	var musicPath string = "./audio"
-	var itemsPerPage = 10
-
-	err := filepath.Walk(musicPath,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if musicPath == path {
-				return nil
-			}
-			musicArr = append(musicArr, path)
-			musicNameArr = append(musicNameArr, info.Name())
-			c++
-			return nil
-		})
+func listLibrary(ctx *Context) {
+	if quizRunning(ctx.GuildID) {
+		ctx.Say("quiz.library_hidden")
+		return
+	}
+	library, err := scanLibrary()
 	if err != nil {
 		log.Println(err)
 	}
-
-	if len(commandArgs) < 2 {
-		s.ChannelMessageSend(m.ChannelID, "oWu use some sub-command: list, play")
+	if len(library) == 0 {
+		ctx.Say("library.empty")
 		return
+	}
 
-	} else if commandArgs[1] == "list" {
-		if len(commandArgs) != 3 {
-			s.ChannelMessageSend(m.ChannelID, "OwU sowwy, but youw shouwd pwowide a pwage.")
-			return
-		} else {
-			page, err := strconv.Atoi(commandArgs[2])
-			if err != nil {
-				log.Println(err)
-			}
-			if page < 1 {
-				s.ChannelMessageSend(m.ChannelID, "Libraries list page should be > 0")
-				return
-			}
-			for i := (page - 1) * itemsPerPage; i < (page)*itemsPerPage; i++ {
-				if i+10 > len(musicArr) {
-					for j := i; j < len(musicNameArr); j++ {
-						musicStrList += strconv.Itoa(j) + ") " + musicNameArr[j] + "\n"
-					}
-					break
-				}
-				musicStrList += strconv.Itoa(i+1) + ") " + musicNameArr[i] + "\n"
-			}
-		}
-		if musicStrList == "" {
-			s.ChannelMessageSend(m.ChannelID, "OwU sowwy, but you page is too big for my small music library\n ( ͡° ͜ʖ ͡°).")
-			return
-
-		}
-		embedExample = &discordgo.MessageEmbed{
-			Author:      &discordgo.MessageEmbedAuthor{},
-			Color:       0x000000,
-			Description: musicStrList,
-			Thumbnail: &discordgo.MessageEmbedThumbnail{
-				URL: "https://i.ytimg.com/vi/zI3EHVxS110/maxresdefault.jpg",
-			},
-			Title: "Music Library Page: [" + commandArgs[2] + " / " + strconv.Itoa(int(len(musicNameArr)/itemsPerPage+1)) + "]",
-		}
-
-		_, err = s.ChannelMessageSendEmbed(m.ChannelID, embedExample)
-		if err != nil {
-			log.Println(err)
+	page := 1
+	if ctx.Has("page") {
+		page = ctx.Int("page")
+	}
+	paginate(ctx, pageCount(len(library)), page, func(page int) (Embed, []*discordgo.File) {
+		start, end := pageBounds(page, len(library))
+		var list strings.Builder
+		for _, track := range library[start:end] {
+			list.WriteString(strconv.Itoa(track.ID) + ") " + track.Name + "\n")
 		}
+		return withWaveform(newEmbed(ctx.T("library.title")).Description(list.String()), library[start].Path)
+	})
+}
 
-	} else if commandArgs[1] == "play" {
-		if len(commandArgs) < 3 {
-			s.ChannelMessageSend(m.ChannelID, "OwU sowwy, but you should provide music index.")
-			return
-		}
-		musicIndex, err := strconv.Atoi(commandArgs[2])
-		if err != nil {
-			log.Println("Error parsing index")
-			return
-		}
-		channel, err := s.State.Channel(m.ChannelID)
-		if err != nil {
-			fmt.Println(err)
-		}
-		guild, err := s.State.Guild(channel.GuildID)
-		if err != nil {
-			fmt.Println(err)
-		}
-		voiceChannel := findVoiceChannelID(guild, m)
-		log.Println("./" + musicArr[musicIndex])
-		playAudioFile("./"+musicArr[musicIndex], channel.GuildID, voiceChannel, "web")
+func playLibraryTrack(ctx *Context) {
+	library, err := scanLibrary()
+	if err != nil {
+		log.Println(err)
 	}
-
+	id := ctx.Int("id")
+	if id > len(library) {
+		ctx.UsageError(&ArgError{Arg: "id", Err: localErr("args.between", "1", strconv.Itoa(len(library)))})
+		return
+	}
+	voiceChannel, ok := authorVoiceChannel(ctx)
+	if !ok {
+		return
+	}
+	log.Println(library[id-1].Path)
+	requestSong(ctx, library[id-1].Path, ctx.GuildID, voiceChannel, "file")
 }
 
-func nextSong(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if len(queue) > 0 {
-		s.ChannelMessageSend(m.ChannelID, "Skipped")
-		go playAudioFile(queue[0].Link, queue[0].Guild, queue[0].Channel, queue[0].Type)
-		queue = append(queue[:0], queue[1:]...)
+func nextSong(ctx *Context) {
+	voice, _ := findVoiceConnection(ctx.GuildID, "")
+	if voice.Mixer != nil && voice.Mixer.Busy() {
+		voice.Mixer.Skip()
+		ctx.Say("skip.done")
+		return
 	}
-	s.ChannelMessageSend(m.ChannelID, "Nothing to skip!")
-
+	if song, ok := popSong(ctx.GuildID); ok {
+		ctx.Say("skip.done")
+		goSafe(func() { playAudioFile(song) })
+		return
+	}
+	ctx.Say("skip.nothing")
 }
 
 // TODO: Implement callable queue and sequential playing stuff
 // TODO: Use folders for music listing
 
-func flex(s *discordgo.Session, m *discordgo.MessageCreate) {
-	s.ChannelMessageSend(m.ChannelID, "Ayy LMAO dats a huge cringe u just posted bro")
+func flex(ctx *Context) {
+	ctx.Say("flex")
 }
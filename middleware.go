@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a command handler. It can act before and after next,
+// or answer itself and not call next at all.
+type Middleware func(next func(ctx *Context)) func(ctx *Context)
+
+// commandMiddleware runs around every handler, first entry outermost.
+// Logging and metrics see how the rest ended, recovery turns a panic
+// anywhere below it into a reply.
+var commandMiddleware = []Middleware{
+	logCommands,
+	recordMetrics,
+	recoverPanics,
+	requireLevel,
+	parseArguments,
+	enforceCooldown,
+}
+
+// How an invocation ended.
+const (
+	statusOK       = "ok"
+	statusUsage    = "usage"
+	statusDenied   = "denied"
+	statusCooldown = "cooldown"
+	statusPanic    = "panic"
+)
+
+// logCommands writes one key=value line per invocation.
+func logCommands(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		start := time.Now()
+		next(ctx)
+		if ctx.status == "" {
+			ctx.status = statusOK
+		}
+		log.Printf("command=%q guild=%s channel=%s user=%s status=%s latency=%s",
+			ctx.Path, ctx.GuildID, ctx.ChannelID, ctx.Author.ID, ctx.status, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// commandMetrics are the counters kept for one command path.
+type commandMetrics struct {
+	Calls   int
+	Errors  int
+	Denied  int
+	Total   time.Duration
+	Slowest time.Duration
+}
+
+var (
+	metrics      = map[string]*commandMetrics{}
+	metricsMutex sync.Mutex
+)
+
+func recordMetrics(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		start := time.Now()
+		next(ctx)
+		elapsed := time.Since(start)
+
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		m, ok := metrics[ctx.Path]
+		if !ok {
+			m = &commandMetrics{}
+			metrics[ctx.Path] = m
+		}
+		m.Calls++
+		switch ctx.status {
+		case statusPanic:
+			m.Errors++
+		case statusDenied, statusCooldown:
+			m.Denied++
+		}
+		m.Total += elapsed
+		if elapsed > m.Slowest {
+			m.Slowest = elapsed
+		}
+	}
+}
+
+// recoverPanics keeps a broken handler from taking the bot down. The
+// author gets an id to report, the log has the same id and the stack.
+func recoverPanics(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				id := newErrorID()
+				ctx.status = statusPanic
+				log.Printf("Panic in command=%q error_id=%s: %v\n%s", ctx.Path, id, r, debug.Stack())
+				ctx.Say("command.panic", id)
+			}
+		}()
+		next(ctx)
+	}
+}
+
+// goSafe runs f on its own goroutine. recoverPanics only covers the
+// handler's goroutine, what a command starts in the background needs this
+// to keep a panic from taking the bot down.
+func goSafe(f func()) {
+	go runSafe(f)
+}
+
+// runSafe runs f, logging a panic instead of passing it on. Callbacks that
+// already have a goroutine of their own, like timers, use it directly.
+func runSafe(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in background task error_id=%s: %v\n%s", newErrorID(), r, debug.Stack())
+		}
+	}()
+	f()
+}
+
+func newErrorID() string {
+	raw := make([]byte, 4)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// requireLevel turns away members below the command's permission level.
+func requireLevel(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		if ctx.Command.Level > PermEveryone {
+			level := memberLevel(ctx.Session, ctx.GuildID, ctx.ChannelID, ctx.Author.ID)
+			if level < ctx.Command.Level {
+				ctx.status = statusDenied
+				ctx.Say("command.denied", ctx.Path, ctx.Command.Level.who(ctx), level)
+				return
+			}
+		}
+		next(ctx)
+	}
+}
+
+// parseArguments fills the Context's argument values, or explains the
+// usage when they don't fit the command.
+func parseArguments(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		var err error
+		ctx.values, ctx.Args, err = ctx.parse()
+		if err != nil {
+			ctx.UsageError(err)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// enforceCooldown comes after parsing, so a mistyped command can be fixed
+// and sent again right away.
+func enforceCooldown(next func(ctx *Context)) func(ctx *Context) {
+	return func(ctx *Context) {
+		if wait, ok := takeCooldown(ctx, ctx.Command, time.Now()); !ok {
+			ctx.status = statusCooldown
+			ctx.Say("command.cooldown", ctx.Path, formatWait(wait))
+			return
+		}
+		next(ctx)
+	}
+}
+
+// showMetrics lists the most used commands since the bot started.
+func showMetrics(ctx *Context) {
+	metricsMutex.Lock()
+	paths := make([]string, 0, len(metrics))
+	for path := range metrics {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if metrics[paths[i]].Calls != metrics[paths[j]].Calls {
+			return metrics[paths[i]].Calls > metrics[paths[j]].Calls
+		}
+		return paths[i] < paths[j]
+	})
+	var lines []string
+	for _, path := range paths {
+		m := metrics[path]
+		average := m.Total / time.Duration(m.Calls)
+		lines = append(lines, ctx.T("metrics.line", m.Calls, path,
+			average.Round(time.Millisecond), m.Slowest.Round(time.Millisecond), m.Errors, m.Denied))
+	}
+	metricsMutex.Unlock()
+
+	if len(lines) == 0 {
+		ctx.Say("metrics.none")
+		return
+	}
+	paginateLines(ctx, ctx.T("metrics.title"), lines)
+}
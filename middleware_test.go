@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeReplier keeps replies instead of sending them.
+type fakeReplier struct {
+	replies  []string
+	messages []*discordgo.MessageSend
+}
+
+func (r *fakeReplier) Reply(text string) error {
+	r.replies = append(r.replies, text)
+	return nil
+}
+
+func (r *fakeReplier) ReplyEmbed(embed *discordgo.MessageEmbed) error {
+	r.replies = append(r.replies, embed.Title+"\n"+embed.Description)
+	return nil
+}
+
+func (r *fakeReplier) ReplyMessage(message *discordgo.MessageSend) (*discordgo.Message, error) {
+	r.messages = append(r.messages, message)
+	for _, embed := range message.Embeds {
+		r.ReplyEmbed(embed)
+	}
+	return &discordgo.Message{ID: "reply", ChannelID: "c1"}, nil
+}
+
+func (r *fakeReplier) last() string {
+	if len(r.replies) == 0 {
+		return ""
+	}
+	return r.replies[len(r.replies)-1]
+}
+
+// newFakeSession returns a session that never talks to Discord. Its state
+// has guild g1 with text channel c1 and these members: "member" without
+// roles, "dj" with a DJ role, "admin" with an Administrator role,
+// "guildowner" owning the guild and "listener" sitting in voice channel
+// v1. "owner" owns the bot.
+func newFakeSession(t *testing.T) *discordgo.Session {
+	s := &discordgo.Session{State: discordgo.NewState()}
+	member := func(id string, roles ...string) *discordgo.Member {
+		return &discordgo.Member{GuildID: "g1", User: &discordgo.User{ID: id, Username: id}, Roles: roles}
+	}
+	err := s.State.GuildAdd(&discordgo.Guild{
+		ID:      "g1",
+		OwnerID: "guildowner",
+		Roles: []*discordgo.Role{
+			{ID: "g1", Name: "@everyone", Permissions: discordgo.PermissionViewChannel | discordgo.PermissionSendMessages},
+			{ID: "role-dj", Name: "DJ"},
+			{ID: "role-admin", Name: "Admins", Permissions: discordgo.PermissionAdministrator},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "c1", GuildID: "g1", Type: discordgo.ChannelTypeGuildText},
+			{ID: "v1", GuildID: "g1", Type: discordgo.ChannelTypeGuildVoice},
+		},
+		Members: []*discordgo.Member{
+			member("member"), member("dj", "role-dj"), member("admin", "role-admin"),
+			member("guildowner"), member("listener"), member("mod"),
+		},
+		VoiceStates: []*discordgo.VoiceState{{GuildID: "g1", ChannelID: "v1", UserID: "listener"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldOwner := botOwnerID
+	botOwnerID = "owner"
+	t.Cleanup(func() { botOwnerID = oldOwner })
+	return s
+}
+
+func newTestContext(s *discordgo.Session, userID string) (*Context, *fakeReplier) {
+	replier := &fakeReplier{}
+	return &Context{
+		Author:    Author{ID: userID, Name: userID},
+		GuildID:   "g1",
+		ChannelID: "c1",
+		Session:   s,
+		replier:   replier,
+	}, replier
+}
+
+func noArgs() (parsedArgs, []string, error) {
+	return parsedArgs{}, nil, nil
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "member")
+	var voice *Voice
+	cmd := &Command{Name: "test panic", Run: func(ctx *Context) {
+		ctx.Reply(voice.Guild)
+	}}
+
+	dispatch(ctx, cmd, []string{"test panic"}, noArgs)
+
+	if ctx.status != statusPanic {
+		t.Errorf("status = %q, want %q", ctx.status, statusPanic)
+	}
+	if !strings.Contains(replier.last(), "something broke") || !strings.Contains(replier.last(), "error ") {
+		t.Fatalf("reply = %q, want an error id", replier.last())
+	}
+	id := replier.last()[strings.LastIndex(replier.last(), " ")+1:]
+	if !strings.Contains(out.String(), "error_id="+id) {
+		t.Errorf("log doesn't mention error %s:\n%s", id, out.String())
+	}
+	if m := metrics["test panic"]; m == nil || m.Errors != 1 || m.Calls != 1 {
+		t.Errorf("metrics = %+v, want 1 call and 1 error", m)
+	}
+}
+
+func TestRunSafe(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	ran := false
+	runSafe(func() {
+		ran = true
+		var voice *Voice
+		log.Println(voice.Guild)
+	})
+	if !ran {
+		t.Fatal("runSafe didn't run the function")
+	}
+	if !strings.Contains(out.String(), "Panic in background task error_id=") {
+		t.Errorf("log doesn't mention the panic:\n%s", out.String())
+	}
+}
+
+func TestRequireLevel(t *testing.T) {
+	s := newFakeSession(t)
+	oldPermissions := guildPermissions
+	guildPermissions = map[string]*guildPerms{
+		"g1": {Users: map[string]PermLevel{"mod": PermModerator}},
+	}
+	defer func() { guildPermissions = oldPermissions }()
+
+	tests := []struct {
+		user  string
+		level PermLevel
+		want  bool
+	}{
+		{"member", PermEveryone, true},
+		{"member", PermDJ, false},
+		{"dj", PermDJ, true},
+		{"dj", PermModerator, false},
+		{"mod", PermDJ, true},
+		{"mod", PermModerator, true},
+		{"mod", PermAdmin, false},
+		{"admin", PermAdmin, true},
+		{"admin", PermOwner, false},
+		{"guildowner", PermAdmin, true},
+		{"owner", PermOwner, true},
+	}
+	for _, tt := range tests {
+		ctx, replier := newTestContext(s, tt.user)
+		ran := false
+		cmd := &Command{Name: "test level", Level: tt.level, Run: func(ctx *Context) { ran = true }}
+		dispatch(ctx, cmd, []string{"test level"}, noArgs)
+
+		if ran != tt.want {
+			t.Errorf("%s running a %s command: ran = %v, want %v (replies %q)", tt.user, tt.level, ran, tt.want, replier.replies)
+		}
+		if !tt.want && !strings.Contains(replier.last(), "is only for "+tt.level.who(ctx)) {
+			t.Errorf("%s running a %s command: reply = %q, want a denial", tt.user, tt.level, replier.last())
+		}
+	}
+}
+
+func TestParseArguments(t *testing.T) {
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "member")
+	ran := false
+	cmd := &Command{Name: "test args", Usage: ".test <count>", Args: []Arg{{Name: "count", Type: ArgInt}},
+		Run: func(ctx *Context) { ran = true }}
+
+	runWith := func(tokens ...string) {
+		dispatch(ctx, cmd, []string{"test args"}, func() (parsedArgs, []string, error) {
+			return parseArgs(cmd, tokens)
+		})
+	}
+	runWith("many")
+	if ran || ctx.status != statusUsage {
+		t.Fatalf("ran = %v, status = %q, want the usage", ran, ctx.status)
+	}
+	if want := "oWu count must be a number. Usage: .test <count>"; replier.last() != want {
+		t.Errorf("reply = %q, want %q", replier.last(), want)
+	}
+
+	ctx.status = ""
+	runWith("3")
+	if !ran || ctx.Int("count") != 3 {
+		t.Errorf("ran = %v, count = %d, want 3", ran, ctx.Int("count"))
+	}
+}
+
+func TestEnforceCooldown(t *testing.T) {
+	s := newFakeSession(t)
+	runs := 0
+	cmd := &Command{Name: "test cooldown", Cooldown: Cooldown{Scope: PerChannel, Burst: 2, Per: time.Minute},
+		Run: func(ctx *Context) { runs++ }}
+
+	var replier *fakeReplier
+	for _, user := range []string{"member", "dj", "admin"} {
+		var ctx *Context
+		ctx, replier = newTestContext(s, user)
+		dispatch(ctx, cmd, []string{"test cooldown"}, noArgs)
+	}
+	if runs != 2 {
+		t.Errorf("runs = %d, want 2, the third user shares the channel's cooldown", runs)
+	}
+	if !strings.HasPrefix(replier.last(), "oWu slow down, try .test cooldown again in ") {
+		t.Errorf("reply = %q, want a cooldown", replier.last())
+	}
+	if m := metrics["test cooldown"]; m == nil || m.Denied != 1 {
+		t.Errorf("metrics = %+v, want 1 turned away", m)
+	}
+
+	ctx, _ := newTestContext(s, "member")
+	ctx.ChannelID = "c2"
+	dispatch(ctx, cmd, []string{"test cooldown"}, noArgs)
+	if runs != 3 {
+		t.Errorf("runs = %d, want 3, another channel has its own cooldown", runs)
+	}
+}
+
+func TestLogCommands(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	s := newFakeSession(t)
+	ctx, _ := newTestContext(s, "member")
+	cmd := &Command{Name: "test log", Run: func(ctx *Context) {}}
+	dispatch(ctx, cmd, []string{"test", "log"}, noArgs)
+
+	want := `command="test log" guild=g1 channel=c1 user=member status=ok latency=`
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("log = %q, want it to contain %q", out.String(), want)
+	}
+}
+
+func TestRunCommandUsesMiddleware(t *testing.T) {
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "member")
+	before := 0
+	if m := metrics["help"]; m != nil {
+		before = m.Calls
+	}
+
+	if !runCommand(ctx, "help 999") {
+		t.Fatal("runCommand(help 999) = false, want true")
+	}
+	if !strings.HasPrefix(replier.last(), "oWu there are only") {
+		t.Errorf("reply = %q, want the help page count", replier.last())
+	}
+	if m := metrics["help"]; m == nil || m.Calls != before+1 {
+		t.Errorf("metrics = %+v, want the call counted", m)
+	}
+	if runCommand(ctx, "nosuchcommand") {
+		t.Error("runCommand(nosuchcommand) = true, want false")
+	}
+}
+
+func TestConnectToVC(t *testing.T) {
+	s := newFakeSession(t)
+	tests := []struct {
+		guild string
+		user  string
+		want  string
+	}{
+		{"unknown", "member", "uWo sowwy but I can't see this server, try again in a bit"},
+		{"g1", "member", "oWu join a voice channel first"},
+	}
+	for _, tt := range tests {
+		ctx, replier := newTestContext(s, tt.user)
+		ctx.GuildID = tt.guild
+		connectToVC(ctx)
+		if replier.last() != tt.want {
+			t.Errorf("connectToVC in %s by %s: reply = %q, want %q", tt.guild, tt.user, replier.last(), tt.want)
+		}
+	}
+
+	ctx, _ := newTestContext(s, "listener")
+	if channel, ok := authorVoiceChannel(ctx); !ok || channel != "v1" {
+		t.Errorf("authorVoiceChannel(listener) = %q, %v, want v1, true", channel, ok)
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"layeh.com/gopus"
+)
+
+// Everything sent to Discord is 48kHz stereo 16-bit PCM encoded to opus
+// in 20ms frames, same as dgvoice does it.
+const (
+	audioChannels  int = 2
+	audioFrameRate int = 48000
+	audioFrameSize int = 960
+	audioMaxBytes  int = (audioFrameSize * 2) * 2
+
+	audioFrameDuration = 20 * time.Millisecond
+)
+
+// pcmSource produces frames of audioFrameSize*audioChannels samples
+// until it returns io.EOF.
+type pcmSource interface {
+	ReadFrame() ([]int16, error)
+	Close() error
+}
+
+type ffmpegSource struct {
+	cmd     *exec.Cmd
+	out     *bufio.Reader
+	cleanup func()
+}
+
+// ffmpegOptions limits playback to a part of the input and applies Gain
+// in dB. Zero values mean from the start, until the end and unchanged.
+type ffmpegOptions struct {
+	Start    time.Duration
+	Duration time.Duration
+	Gain     float64
+}
+
+func newFFmpegSource(file string, opts ffmpegOptions) (*ffmpegSource, error) {
+	var args []string
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', 3, 64))
+	}
+	if opts.Duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(opts.Duration.Seconds(), 'f', 3, 64))
+	}
+	args = append(args, "-i", file)
+	if opts.Gain != 0 {
+		args = append(args, "-af", "volume="+strconv.FormatFloat(opts.Gain, 'f', 2, 64)+"dB")
+	}
+	args = append(args, "-f", "s16le", "-ar", strconv.Itoa(audioFrameRate), "-ac", strconv.Itoa(audioChannels), "pipe:1")
+	run := exec.Command("ffmpeg", args...)
+	ffmpegout, err := run.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = run.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &ffmpegSource{
+		cmd: run,
+		out: bufio.NewReaderSize(ffmpegout, 16384),
+	}, nil
+}
+
+func (f *ffmpegSource) ReadFrame() ([]int16, error) {
+	frame := make([]int16, audioFrameSize*audioChannels)
+	err := binary.Read(f.out, binary.LittleEndian, &frame)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return frame, err
+}
+
+func (f *ffmpegSource) Close() error {
+	f.cmd.Process.Kill()
+	err := f.cmd.Wait()
+	if f.cleanup != nil {
+		f.cleanup()
+	}
+	return err
+}
+
+// bufferedSource reads ahead of its consumer so ffmpeg start-up is hidden
+// and the end of a track is known before it is reached.
+type bufferedSource struct {
+	inner pcmSource
+	size  int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames [][]int16
+	err    error
+	closed bool
+}
+
+func newBufferedSource(inner pcmSource, size int) *bufferedSource {
+	b := &bufferedSource{inner: inner, size: size}
+	b.cond = sync.NewCond(&b.mu)
+	go b.fill()
+	return b
+}
+
+func (b *bufferedSource) fill() {
+	for {
+		b.mu.Lock()
+		for len(b.frames) >= b.size && !b.closed {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		frame, err := b.inner.ReadFrame()
+
+		b.mu.Lock()
+		if err != nil {
+			b.err = err
+		} else {
+			b.frames = append(b.frames, frame)
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *bufferedSource) ReadFrame() ([]int16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.frames) == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.frames) > 0 {
+		frame := b.frames[0]
+		b.frames = b.frames[1:]
+		b.cond.Broadcast()
+		return frame, nil
+	}
+	if b.closed {
+		return nil, io.EOF
+	}
+	return nil, b.err
+}
+
+// Remaining returns how many frames are left once the end of the input has
+// been read, ok is false while that is still unknown.
+func (b *bufferedSource) Remaining() (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.frames), b.err != nil
+}
+
+func (b *bufferedSource) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return b.inner.Close()
+}
+
+const (
+	maxCrossfade = 12 * time.Second
+	// Music is read this far ahead, enough to see a full crossfade coming.
+	musicLookahead = int(maxCrossfade / audioFrameDuration)
+)
+
+// musicTrack is a handle on a track given to the mixer. done is closed once
+// it stops playing, ended then tells whether it played out (or was skipped)
+// rather than being stopped or replaced.
+type musicTrack struct {
+	src    *bufferedSource
+	done   chan struct{}
+	ended  bool
+	frames int
+}
+
+func (t *musicTrack) finish(ended bool) {
+	t.src.Close()
+	t.ended = ended
+	close(t.done)
+}
+
+// Mixer owns the opus stream of one voice connection. It plays a single
+// music track, with the next one lined up for a gapless switch or a
+// crossfade, and mixes any number of overlays (clips, TTS) on top.
+type Mixer struct {
+	vc *discordgo.VoiceConnection
+
+	mu        sync.Mutex
+	music     *musicTrack
+	next      *musicTrack
+	crossfade int
+	overlays  []pcmSource
+	// stopping are overlays to end before the next frame is read.
+	stopping []pcmSource
+	// paused holds the music where it is, overlays keep playing.
+	paused bool
+
+	wake chan struct{}
+	quit chan struct{}
+	once sync.Once
+}
+
+func newMixer(vc *discordgo.VoiceConnection) *Mixer {
+	mixer := &Mixer{
+		vc:   vc,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+	}
+	go mixer.run()
+	return mixer
+}
+
+// StartMusic replaces the current and next music tracks with src.
+func (mx *Mixer) StartMusic(src pcmSource) *musicTrack {
+	track := &musicTrack{src: newBufferedSource(src, musicLookahead), done: make(chan struct{})}
+
+	mx.mu.Lock()
+	mx.stopMusicLocked()
+	mx.music = track
+	mx.mu.Unlock()
+
+	mx.notify()
+	return track
+}
+
+// PlayMusic replaces the current music track with src and blocks until it
+// finishes or is stopped.
+func (mx *Mixer) PlayMusic(src pcmSource) {
+	track := mx.StartMusic(src)
+	select {
+	case <-track.done:
+	case <-mx.quit:
+	}
+}
+
+// QueueMusic lines src up to play right after the current track. It starts
+// reading src straight away and replaces whatever was lined up before.
+func (mx *Mixer) QueueMusic(src pcmSource) *musicTrack {
+	mx.mu.Lock()
+	if mx.music == nil {
+		mx.mu.Unlock()
+		return mx.StartMusic(src)
+	}
+	track := &musicTrack{src: newBufferedSource(src, musicLookahead), done: make(chan struct{})}
+	if mx.next != nil {
+		mx.next.finish(false)
+	}
+	mx.next = track
+	mx.mu.Unlock()
+	return track
+}
+
+// ClearNext drops the track lined up by QueueMusic.
+func (mx *Mixer) ClearNext() {
+	mx.mu.Lock()
+	if mx.next != nil {
+		mx.next.finish(false)
+		mx.next = nil
+	}
+	mx.mu.Unlock()
+}
+
+// Skip ends the current track and moves on to the next one, if any.
+func (mx *Mixer) Skip() {
+	mx.mu.Lock()
+	if mx.music != nil {
+		mx.music.finish(true)
+		mx.music, mx.next = mx.next, nil
+	}
+	mx.mu.Unlock()
+	mx.notify()
+}
+
+// StopMusic stops the music tracks, overlays keep playing.
+func (mx *Mixer) StopMusic() {
+	mx.mu.Lock()
+	mx.stopMusicLocked()
+	mx.mu.Unlock()
+}
+
+func (mx *Mixer) stopMusicLocked() {
+	if mx.music != nil {
+		mx.music.finish(false)
+		mx.music = nil
+	}
+	if mx.next != nil {
+		mx.next.finish(false)
+		mx.next = nil
+	}
+}
+
+// Current returns the track playing right now, nil when there is none.
+func (mx *Mixer) Current() *musicTrack {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	return mx.music
+}
+
+// SetCrossfade sets how long the end of a track overlaps the start of the
+// next one, 0 switches without overlap.
+func (mx *Mixer) SetCrossfade(d time.Duration) {
+	if d > maxCrossfade {
+		d = maxCrossfade
+	}
+	mx.mu.Lock()
+	mx.crossfade = int(d / audioFrameDuration)
+	mx.mu.Unlock()
+}
+
+// Overlay mixes src over whatever is playing right now.
+func (mx *Mixer) Overlay(src pcmSource) {
+	mx.mu.Lock()
+	mx.overlays = append(mx.overlays, src)
+	mx.mu.Unlock()
+	mx.notify()
+}
+
+// StopOverlay ends src early, if it's still playing. The mixer closes it
+// between frames, so it's never closed in the middle of a read.
+func (mx *Mixer) StopOverlay(src pcmSource) {
+	mx.mu.Lock()
+	mx.stopping = append(mx.stopping, src)
+	mx.mu.Unlock()
+	mx.notify()
+}
+
+// removeOverlayLocked takes src out of the overlays and closes it, unless
+// it already finished.
+func (mx *Mixer) removeOverlayLocked(src pcmSource) {
+	for i, o := range mx.overlays {
+		if o == src {
+			mx.overlays = append(mx.overlays[:i], mx.overlays[i+1:]...)
+			src.Close()
+			return
+		}
+	}
+}
+
+// SetPaused holds or resumes the music tracks.
+func (mx *Mixer) SetPaused(paused bool) {
+	mx.mu.Lock()
+	mx.paused = paused
+	mx.mu.Unlock()
+	mx.notify()
+}
+
+// Position is how far into the current music track playback is.
+func (mx *Mixer) Position() time.Duration {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	if mx.music == nil {
+		return 0
+	}
+	return time.Duration(mx.music.frames) * time.Second * time.Duration(audioFrameSize) / time.Duration(audioFrameRate)
+}
+
+// Busy reports whether a music track is playing.
+func (mx *Mixer) Busy() bool {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	return mx.music != nil
+}
+
+func (mx *Mixer) Close() {
+	mx.once.Do(func() {
+		close(mx.quit)
+		mx.mu.Lock()
+		mx.stopMusicLocked()
+		for _, overlay := range mx.overlays {
+			overlay.Close()
+		}
+		mx.overlays = nil
+		mx.mu.Unlock()
+	})
+}
+
+func (mx *Mixer) notify() {
+	select {
+	case mx.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (mx *Mixer) run() {
+	encoder, err := gopus.NewEncoder(audioFrameRate, audioChannels, gopus.Audio)
+	if err != nil {
+		log.Println("Error creating opus encoder,", err)
+		return
+	}
+	speaking := false
+
+	for {
+		frame, ok := mx.mix()
+		if !ok {
+			if speaking {
+				mx.vc.Speaking(false)
+				speaking = false
+			}
+			select {
+			case <-mx.wake:
+				continue
+			case <-mx.quit:
+				return
+			}
+		}
+		if !speaking {
+			err = mx.vc.Speaking(true)
+			if err != nil {
+				log.Println("Couldn't set speaking,", err)
+			}
+			speaking = true
+		}
+
+		opus, err := encoder.Encode(frame, audioFrameSize, audioMaxBytes)
+		if err != nil {
+			log.Println("Opus encoding error,", err)
+			continue
+		}
+		if !mx.waitReady() {
+			return
+		}
+		select {
+		case mx.vc.OpusSend <- opus:
+		case <-mx.quit:
+			return
+		}
+	}
+}
+
+// waitReady blocks while the voice connection is down, checking once a
+// frame, so audio waits for a reconnect instead of being dropped. It is
+// false when the mixer was closed meanwhile.
+func (mx *Mixer) waitReady() bool {
+	for {
+		mx.vc.RLock()
+		ready := mx.vc.Ready && mx.vc.OpusSend != nil
+		mx.vc.RUnlock()
+		if ready {
+			return true
+		}
+		select {
+		case <-time.After(audioFrameDuration):
+		case <-mx.quit:
+			return false
+		}
+	}
+}
+
+// mix reads one frame from every active source and sums them. It returns
+// false when there is nothing to play.
+func (mx *Mixer) mix() ([]int16, bool) {
+	mx.mu.Lock()
+	for _, src := range mx.stopping {
+		mx.removeOverlayLocked(src)
+	}
+	mx.stopping = nil
+	music, next, crossfade := mx.music, mx.next, mx.crossfade
+	overlays := append([]pcmSource(nil), mx.overlays...)
+	if mx.paused {
+		music, next = nil, nil
+	}
+	mx.mu.Unlock()
+
+	if music == nil && len(overlays) == 0 {
+		return nil, false
+	}
+
+	sum := make([]int32, audioFrameSize*audioChannels)
+	add := func(src pcmSource, gain float64) bool {
+		frame, err := src.ReadFrame()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Error reading audio,", err)
+			}
+			return false
+		}
+		for i, sample := range frame {
+			sum[i] += int32(float64(sample) * gain)
+		}
+		return true
+	}
+
+	musicFinished, nextStarted := false, false
+	if music != nil {
+		remaining, known := music.src.Remaining()
+		if next != nil && crossfade > 0 && known && remaining < crossfade {
+			// Equal power fade over the last crossfade frames.
+			progress := 1 - float64(remaining)/float64(crossfade)
+			musicFinished = !add(music.src, math.Cos(progress*math.Pi/2))
+			nextStarted = add(next.src, math.Sin(progress*math.Pi/2))
+		} else {
+			musicFinished = !add(music.src, 1)
+		}
+		// Switch to a lined up track within the same frame, no gap.
+		if musicFinished && next != nil && !nextStarted {
+			nextStarted = add(next.src, 1)
+		}
+	}
+
+	var finished []pcmSource
+	for _, overlay := range overlays {
+		if !add(overlay, 1) {
+			finished = append(finished, overlay)
+		}
+	}
+
+	mx.mu.Lock()
+	if music != nil && mx.music == music {
+		if musicFinished {
+			music.finish(true)
+			mx.music, mx.next = mx.next, nil
+		} else {
+			music.frames++
+		}
+		if nextStarted && (mx.music == next || mx.next == next) {
+			next.frames++
+		}
+	}
+	for _, overlay := range finished {
+		mx.removeOverlayLocked(overlay)
+	}
+	mx.mu.Unlock()
+
+	return clampFrame(sum), true
+}
+
+func clampFrame(sum []int32) []int16 {
+	frame := make([]int16, len(sum))
+	for i, sample := range sum {
+		if sample > 32767 {
+			sample = 32767
+		} else if sample < -32768 {
+			sample = -32768
+		}
+		frame[i] = int16(sample)
+	}
+	return frame
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// constSource plays frames of one value until it runs out.
+type constSource struct {
+	value  int16
+	frames int
+	closed bool
+}
+
+func (s *constSource) ReadFrame() ([]int16, error) {
+	if s.closed || s.frames == 0 {
+		return nil, io.EOF
+	}
+	s.frames--
+	frame := make([]int16, audioFrameSize*audioChannels)
+	for i := range frame {
+		frame[i] = s.value
+	}
+	return frame, nil
+}
+
+func (s *constSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestMixerPauseAndStopOverlay(t *testing.T) {
+	mx := &Mixer{wake: make(chan struct{}, 1), quit: make(chan struct{})}
+	music := &constSource{value: 1, frames: 100}
+	overlay := &constSource{value: 10, frames: 100}
+	track := mx.StartMusic(music)
+	mx.Overlay(overlay)
+
+	frame, _ := mx.mix()
+	if frame[0] != 11 {
+		t.Fatalf("sample = %d, want music and overlay", frame[0])
+	}
+
+	mx.SetPaused(true)
+	frame, _ = mx.mix()
+	if frame[0] != 10 || track.frames != 1 {
+		t.Errorf("paused: sample = %d after %d music frames, want the overlay alone after 1", frame[0], track.frames)
+	}
+
+	mx.StopOverlay(overlay)
+	if _, ok := mx.mix(); ok {
+		t.Error("paused mixer with its overlay stopped still has something to play")
+	}
+	if !overlay.closed {
+		t.Error("stopped overlay wasn't closed")
+	}
+
+	mx.SetPaused(false)
+	frame, _ = mx.mix()
+	if frame[0] != 1 || track.frames != 2 {
+		t.Errorf("resumed: sample = %d after %d music frames, want the music going on", frame[0], track.frames)
+	}
+	mx.Close()
+}
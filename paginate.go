@@ -0,0 +1,255 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	pageButtonID = "page"
+	itemsPerPage = 10
+	// pageTimeout is how long the controls keep working after the last
+	// page turn.
+	pageTimeout = 2 * time.Minute
+)
+
+// pageControls are the buttons under a paginated list, in order. Reacting
+// with the same emoji turns the page too.
+var pageControls = []struct {
+	Emoji  string
+	Action string
+}{
+	{"⏮", "first"},
+	{"◀", "previous"},
+	{"▶", "next"},
+	{"⏭", "last"},
+}
+
+// pageRenderer draws page n, counted from 1, of a list. The files are sent
+// along with it, for images the embed shows as attachment://.
+type pageRenderer func(page int) (Embed, []*discordgo.File)
+
+// paginator is a list message whose controls still work.
+type paginator struct {
+	owner   string
+	guild   string
+	channel string
+	page    int
+	pages   int
+	render  pageRenderer
+	expiry  *time.Timer
+}
+
+var (
+	paginators      = map[string]*paginator{}
+	paginatorsMutex sync.Mutex
+)
+
+// pageCount is how many pages of itemsPerPage items fit n items, at least
+// one so an empty list still says so.
+func pageCount(n int) int {
+	if n <= 0 {
+		return 1
+	}
+	return (n + itemsPerPage - 1) / itemsPerPage
+}
+
+// pageBounds returns the slice bounds of the items on page.
+func pageBounds(page int, n int) (int, int) {
+	start := (page - 1) * itemsPerPage
+	end := start + itemsPerPage
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// paginate answers with page of a list and, when there is more than one
+// page, the controls to turn them. Only the author of ctx can use them.
+func paginate(ctx *Context, pages int, page int, render pageRenderer) {
+	if page < 1 || page > pages {
+		ctx.Say("page.out_of_range", pages)
+		return
+	}
+	embed, files := renderPage(ctx.GuildID, render, page, pages)
+	message := &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}, Files: files}
+	if pages > 1 {
+		message.Components = pageButtons(page, pages)
+	}
+	sent := ctx.ReplyMessage(message)
+	if sent == nil || pages == 1 {
+		return
+	}
+
+	p := &paginator{owner: ctx.Author.ID, guild: ctx.GuildID, channel: sent.ChannelID, page: page, pages: pages, render: render}
+	p.expiry = time.AfterFunc(pageTimeout, func() {
+		runSafe(func() { expirePaginator(ctx.Session, sent.ID) })
+	})
+	paginatorsMutex.Lock()
+	paginators[sent.ID] = p
+	paginatorsMutex.Unlock()
+}
+
+// paginateLines pages through lines, itemsPerPage of them on each page of
+// an embed titled title.
+func paginateLines(ctx *Context, title string, lines []string) {
+	paginate(ctx, pageCount(len(lines)), 1, func(page int) (Embed, []*discordgo.File) {
+		start, end := pageBounds(page, len(lines))
+		return newEmbed(title).Description(strings.Join(lines[start:end], "\n")), nil
+	})
+}
+
+// renderPage draws a page for guild and says which one it is in the
+// footer.
+func renderPage(guild string, render pageRenderer, page int, pages int) (*discordgo.MessageEmbed, []*discordgo.File) {
+	embed, files := render(page)
+	footer := embed.footer
+	if footer == "" {
+		footer = guildTheme(guild).Footer
+	}
+	pageFooter := tr(guild, "page.footer", page, pages)
+	if footer != "" {
+		pageFooter += " · " + footer
+	}
+	return embed.Footer(pageFooter).Build(guild), files
+}
+
+func pageButtons(page int, pages int) []discordgo.MessageComponent {
+	var buttons []discordgo.MessageComponent
+	for _, control := range pageControls {
+		backwards := control.Action == "first" || control.Action == "previous"
+		buttons = append(buttons, discordgo.Button{
+			Emoji:    &discordgo.ComponentEmoji{Name: control.Emoji},
+			Style:    discordgo.SecondaryButton,
+			CustomID: pageButtonID + ":" + control.Action,
+			Disabled: (backwards && page == 1) || (!backwards && page == pages),
+		})
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// turnPage moves the paginator of message by action for user and returns
+// its new state. ok is false when the message has none, owner is false
+// when user isn't the one who asked for the list.
+func turnPage(message string, user string, action string) (p paginator, owner bool, ok bool) {
+	paginatorsMutex.Lock()
+	defer paginatorsMutex.Unlock()
+	current, ok := paginators[message]
+	if !ok {
+		return paginator{}, false, false
+	}
+	if current.owner != user {
+		return paginator{}, false, true
+	}
+	switch action {
+	case "first":
+		current.page = 1
+	case "previous":
+		if current.page > 1 {
+			current.page--
+		}
+	case "next":
+		if current.page < current.pages {
+			current.page++
+		}
+	case "last":
+		current.page = current.pages
+	}
+	current.expiry.Reset(pageTimeout)
+	return *current, true, true
+}
+
+// handlePageButton turns the page of the list the button is under.
+func handlePageButton(s *discordgo.Session, i *discordgo.Interaction, action string) {
+	user := i.User
+	if i.Member != nil {
+		user = i.Member.User
+	}
+	p, owner, ok := turnPage(i.Message.ID, user.ID, action)
+	response := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}
+	switch {
+	case !ok:
+		response.Data.Content = tr(i.GuildID, "page.expired")
+	case !owner:
+		response.Data.Content = tr(i.GuildID, "page.not_yours")
+	default:
+		embed, files := renderPage(p.guild, p.render, p.page, p.pages)
+		response.Type = discordgo.InteractionResponseUpdateMessage
+		response.Data = &discordgo.InteractionResponseData{
+			Embeds:      []*discordgo.MessageEmbed{embed},
+			Components:  pageButtons(p.page, p.pages),
+			Files:       files,
+			Attachments: &[]*discordgo.MessageAttachment{},
+		}
+	}
+	err := s.InteractionRespond(i, response)
+	if err != nil {
+		log.Println("Error answering interaction,", err)
+	}
+}
+
+// pageReactionHandler lets reacting with a control's emoji work like
+// pressing it.
+func pageReactionHandler(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+	action := ""
+	for _, control := range pageControls {
+		if r.Emoji.Name == control.Emoji {
+			action = control.Action
+		}
+	}
+	if action == "" {
+		return
+	}
+	p, owner, ok := turnPage(r.MessageID, r.UserID, action)
+	if !ok || !owner {
+		return
+	}
+	embed, files := renderPage(p.guild, p.render, p.page, p.pages)
+	components := pageButtons(p.page, p.pages)
+	_, err := editMessage(s, &discordgo.MessageEdit{
+		ID:          r.MessageID,
+		Channel:     r.ChannelID,
+		Embeds:      &[]*discordgo.MessageEmbed{embed},
+		Components:  &components,
+		Files:       files,
+		Attachments: &[]*discordgo.MessageAttachment{},
+	})
+	if err != nil {
+		log.Println("Error turning page,", err)
+	}
+	// Take the reaction back so the same arrow can be used again. This
+	// needs Manage Messages, without it the member removes it themselves.
+	removeReaction(s, r.ChannelID, r.MessageID, r.Emoji.APIName(), r.UserID)
+}
+
+// expirePaginator forgets a list and takes its controls away.
+func expirePaginator(s *discordgo.Session, message string) {
+	paginatorsMutex.Lock()
+	p, ok := paginators[message]
+	delete(paginators, message)
+	paginatorsMutex.Unlock()
+	if !ok {
+		return
+	}
+	_, err := editMessage(s, &discordgo.MessageEdit{
+		ID:         message,
+		Channel:    p.channel,
+		Components: &[]discordgo.MessageComponent{},
+	})
+	if err != nil {
+		log.Println("Error removing page controls,", err)
+	}
+}
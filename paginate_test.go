@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPageCount(t *testing.T) {
+	tests := []struct {
+		items int
+		want  int
+	}{
+		{0, 1},
+		{1, 1},
+		{10, 1},
+		{11, 2},
+		{20, 2},
+		{21, 3},
+	}
+	for _, tt := range tests {
+		if got := pageCount(tt.items); got != tt.want {
+			t.Errorf("pageCount(%d) = %d, want %d", tt.items, got, tt.want)
+		}
+	}
+
+	if start, end := pageBounds(3, 25); start != 20 || end != 25 {
+		t.Errorf("pageBounds(3, 25) = %d, %d, want 20, 25", start, end)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "member")
+	lines := make([]string, 25)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", i+1)
+	}
+	paginateLines(ctx, "Test list", lines)
+	defer func() {
+		paginatorsMutex.Lock()
+		paginators["reply"].expiry.Stop()
+		delete(paginators, "reply")
+		paginatorsMutex.Unlock()
+	}()
+
+	if !strings.HasPrefix(replier.last(), "Test list\nx\nxx\n") || strings.Count(replier.last(), "\n") != 10 {
+		t.Errorf("first page = %q, want lines 1 to 10", replier.last())
+	}
+	if _, owner, ok := turnPage("reply", "dj", "next"); !ok || owner {
+		t.Errorf("turnPage by someone else: owner = %v, ok = %v, want false, true", owner, ok)
+	}
+	p, owner, ok := turnPage("reply", "member", "last")
+	if !ok || !owner || p.page != 3 {
+		t.Fatalf("turnPage(last) = page %d, owner %v, ok %v, want page 3", p.page, owner, ok)
+	}
+	embed, _ := renderPage("g1", p.render, p.page, p.pages)
+	if embed.Footer.Text != "Page 3/3" || strings.Count(embed.Description, "\n") != 4 {
+		t.Errorf("last page = %q %q, want 5 lines on page 3/3", embed.Description, embed.Footer.Text)
+	}
+	if p, _, _ = turnPage("reply", "member", "next"); p.page != 3 {
+		t.Errorf("turnPage(next) past the end = page %d, want 3", p.page)
+	}
+
+	buttons := pageButtons(3, 3)[0].(discordgo.ActionsRow).Components
+	if buttons[0].(discordgo.Button).Disabled || !buttons[3].(discordgo.Button).Disabled {
+		t.Error("on the last page only ▶ and ⏭ should be disabled")
+	}
+
+	paginate(ctx, 3, 4, p.render)
+	if replier.last() != "oWu there are only 3 pages" {
+		t.Errorf("reply = %q, want the page count", replier.last())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// PermLevel is how much a member may do with the bot. Every level can do
+// everything the levels below it can.
+type PermLevel int
+
+const (
+	PermEveryone PermLevel = iota
+	PermDJ
+	PermModerator
+	PermAdmin
+	// PermOwner is whoever owns the bot's Discord application, it can't
+	// be given out with .perms.
+	PermOwner
+)
+
+var permLevelNames = []string{"everyone", "dj", "moderator", "admin", "owner"}
+
+// Levels .perms can give, in the order they are shown.
+var assignableLevels = []string{"everyone", "dj", "moderator", "admin"}
+
+func (level PermLevel) String() string {
+	if level < 0 || int(level) >= len(permLevelNames) {
+		return fmt.Sprintf("level %d", int(level))
+	}
+	return permLevelNames[level]
+}
+
+// who names the members holding level, for denials and .help.
+func (level PermLevel) who(ctx *Context) string {
+	return ctx.T("perms.who." + level.String())
+}
+
+// Levels are stored by name so the data files stay readable.
+func (level PermLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+func (level *PermLevel) UnmarshalText(text []byte) error {
+	parsed, ok := parsePermLevel(string(text))
+	if !ok {
+		return fmt.Errorf("unknown permission level %q", text)
+	}
+	*level = parsed
+	return nil
+}
+
+func parsePermLevel(name string) (PermLevel, bool) {
+	for i, known := range permLevelNames {
+		if strings.EqualFold(name, known) {
+			return PermLevel(i), true
+		}
+	}
+	return PermEveryone, false
+}
+
+// guildPerms maps a guild's roles and members to levels. Members without
+// an entry are still DJs with a role called DJ and admins with the
+// Administrator or Manage Server permission.
+type guildPerms struct {
+	Roles map[string]PermLevel `json:"roles"`
+	Users map[string]PermLevel `json:"users"`
+}
+
+var (
+	guildPermissions = map[string]*guildPerms{}
+	permissionsMutex sync.Mutex
+
+	botOwnerID    string
+	botOwnerMutex sync.Mutex
+)
+
+// memberLevel works out the highest level userID has in guild.
+func memberLevel(s *discordgo.Session, guild string, channel string, userID string) PermLevel {
+	if isBotOwner(s, userID) {
+		return PermOwner
+	}
+	if isGuildAdmin(s, channel, userID) {
+		return PermAdmin
+	}
+
+	level := PermEveryone
+	raise := func(to PermLevel) {
+		if to > level {
+			level = to
+		}
+	}
+	member, err := s.State.Member(guild, userID)
+	if err != nil {
+		member, err = s.GuildMember(guild, userID)
+	}
+	if err != nil {
+		log.Println("Error getting member,", err)
+		member = &discordgo.Member{}
+	}
+
+	permissionsMutex.Lock()
+	perms := guildPermissions[guild]
+	if perms != nil {
+		raise(perms.Users[userID])
+		for _, role := range member.Roles {
+			raise(perms.Roles[role])
+		}
+	}
+	permissionsMutex.Unlock()
+
+	for _, roleID := range member.Roles {
+		role, err := s.State.Role(guild, roleID)
+		if err == nil && strings.EqualFold(role.Name, "DJ") {
+			raise(PermDJ)
+		}
+	}
+	return level
+}
+
+// isBotOwner reports whether userID owns the bot's Discord application.
+// The owner is looked up once and remembered.
+func isBotOwner(s *discordgo.Session, userID string) bool {
+	botOwnerMutex.Lock()
+	defer botOwnerMutex.Unlock()
+	if botOwnerID == "" {
+		app, err := s.Application("@me")
+		if err != nil {
+			log.Println("Error getting application,", err)
+			return false
+		}
+		if app.Owner == nil {
+			return false
+		}
+		botOwnerID = app.Owner.ID
+	}
+	return botOwnerID == userID
+}
+
+// atLeast is requireLevel for commands where only some uses are
+// restricted, it turns the author away unless they have level.
+func (ctx *Context) atLeast(level PermLevel) bool {
+	have := memberLevel(ctx.Session, ctx.GuildID, ctx.ChannelID, ctx.Author.ID)
+	if have >= level {
+		return true
+	}
+	ctx.status = statusDenied
+	ctx.Say("command.denied", ctx.Path, level.who(ctx), have)
+	return false
+}
+
+func showPermissions(ctx *Context) {
+	var lines []string
+	permissionsMutex.Lock()
+	if perms := guildPermissions[ctx.GuildID]; perms != nil {
+		for role, level := range perms.Roles {
+			lines = append(lines, fmt.Sprintf("<@&%s>: %s", role, level))
+		}
+		for user, level := range perms.Users {
+			lines = append(lines, fmt.Sprintf("<@%s>: %s", user, level))
+		}
+	}
+	permissionsMutex.Unlock()
+	sort.Strings(lines)
+
+	level := memberLevel(ctx.Session, ctx.GuildID, ctx.ChannelID, ctx.Author.ID)
+	text := ctx.T("perms.yours", level) + "\n"
+	if len(lines) == 0 {
+		text += ctx.T("perms.none")
+	} else {
+		text += strings.Join(lines, "\n")
+	}
+	ctx.ReplyEmbed(newEmbed(ctx.T("perms.title")).Description(text))
+}
+
+func setRoleLevel(ctx *Context) {
+	setPermLevel(ctx, func(perms *guildPerms) map[string]PermLevel { return perms.Roles }, ctx.String("role"))
+}
+
+func setUserLevel(ctx *Context) {
+	setPermLevel(ctx, func(perms *guildPerms) map[string]PermLevel { return perms.Users }, ctx.String("user"))
+}
+
+// setPermLevel gives id a level in the map picked from the guild's
+// permissions, everyone removes the entry.
+func setPermLevel(ctx *Context, pick func(*guildPerms) map[string]PermLevel, id string) {
+	level, _ := parsePermLevel(ctx.String("level"))
+
+	permissionsMutex.Lock()
+	perms, ok := guildPermissions[ctx.GuildID]
+	if !ok {
+		perms = &guildPerms{}
+		guildPermissions[ctx.GuildID] = perms
+	}
+	if perms.Roles == nil {
+		perms.Roles = map[string]PermLevel{}
+	}
+	if perms.Users == nil {
+		perms.Users = map[string]PermLevel{}
+	}
+	if level == PermEveryone {
+		delete(pick(perms), id)
+	} else {
+		pick(perms)[id] = level
+	}
+	err := saveData("perms", guildPermissions)
+	permissionsMutex.Unlock()
+	if err != nil {
+		log.Println("Error saving permissions,", err)
+		ctx.Say("perms.save_failed")
+		return
+	}
+	ctx.Say("perms.updated")
+}
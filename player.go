@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const queuePollInterval = 500 * time.Millisecond
+
+var (
+	maxSongHistory = 50
+
+	queueMutex sync.Mutex
+
+	// Guilds whose player is working through the queue. It's kept by
+	// guild, not in voiceConnections, which is rebuilt when voice drops.
+	playingGuilds = map[string]bool{}
+	playingMutex  sync.Mutex
+
+	// startQueue plays a song and the guild's queue after it, tests stand
+	// in for it to do without ffmpeg.
+	startQueue = playQueue
+
+	// Crossfade length in seconds per guild, 0 or missing plays gapless.
+	crossfadeGuilds = map[string]int{}
+	crossfadeMutex  sync.Mutex
+
+	// Songs that played per guild, newest first.
+	songHistory      = map[string][]Song{}
+	songHistoryMutex sync.Mutex
+)
+
+func newSongSource(song Song) (pcmSource, error) {
+	return newFFmpegSource(song.Link, ffmpegOptions{Gain: normalizationGain(song.Link)})
+}
+
+// startPlaying marks guild's player busy, false means it already was.
+func startPlaying(guild string) bool {
+	playingMutex.Lock()
+	defer playingMutex.Unlock()
+	if playingGuilds[guild] {
+		return false
+	}
+	playingGuilds[guild] = true
+	return true
+}
+
+func stopPlaying(guild string) {
+	playingMutex.Lock()
+	delete(playingGuilds, guild)
+	playingMutex.Unlock()
+}
+
+// peekSong returns the first queued song of guild.
+func peekSong(guild string) (Song, bool) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for _, song := range queue {
+		if song.Guild == guild {
+			return song, true
+		}
+	}
+	return Song{}, false
+}
+
+// popSong removes and returns the first queued song of guild.
+func popSong(guild string) (Song, bool) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for i, song := range queue {
+		if song.Guild == guild {
+			queue = append(queue[:i], queue[i+1:]...)
+			return song, true
+		}
+	}
+	return Song{}, false
+}
+
+// guildQueue returns the songs queued in guild, next first.
+func guildQueue(guild string) []Song {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	var songs []Song
+	for _, song := range queue {
+		if song.Guild == guild {
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
+func recordSong(song Song) {
+	songHistoryMutex.Lock()
+	defer songHistoryMutex.Unlock()
+	history := append([]Song{song}, songHistory[song.Guild]...)
+	if len(history) > maxSongHistory {
+		history = history[:maxSongHistory]
+	}
+	songHistory[song.Guild] = history
+}
+
+// songLabel is how a song is shown in lists, files by name and the rest
+// by link.
+func songLabel(song Song) string {
+	if song.Type == "file" {
+		return filepath.Base(song.Link)
+	}
+	return song.Link
+}
+
+func showQueue(ctx *Context) {
+	songs := guildQueue(ctx.GuildID)
+	if len(songs) == 0 {
+		ctx.Say("queue.empty")
+		return
+	}
+	var lines []string
+	for i, song := range songs {
+		lines = append(lines, fmt.Sprintf("%d) %s — <@%s>", i+1, songLabel(song), song.Requester))
+	}
+	paginateLines(ctx, ctx.T("queue.title"), lines)
+}
+
+func showHistory(ctx *Context) {
+	songHistoryMutex.Lock()
+	songs := append([]Song(nil), songHistory[ctx.GuildID]...)
+	songHistoryMutex.Unlock()
+	if len(songs) == 0 {
+		ctx.Say("history.empty")
+		return
+	}
+	var lines []string
+	for i, song := range songs {
+		lines = append(lines, fmt.Sprintf("%d) %s — <@%s>", i+1, songLabel(song), song.Requester))
+	}
+	paginateLines(ctx, ctx.T("history.title"), lines)
+}
+
+// removeSong drops the first queued entry equal to song.
+func removeSong(song Song) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for i, queued := range queue {
+		if queued == song {
+			queue = append(queue[:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// playQueue plays song and then keeps going through the guild's queue
+// until it runs dry or the music is stopped. The head of the queue is
+// always lined up in the mixer ahead of time, so the switch to it has no
+// gap (or is crossfaded) and a skip starts it right away.
+func playQueue(mixer *Mixer, song Song) {
+	src, err := newSongSource(song)
+	if err != nil {
+		log.Println("Error starting ffmpeg,", err)
+		return
+	}
+	track := mixer.StartMusic(src)
+
+	for {
+		setCurrentSong(song)
+
+		var next *musicTrack
+		var nextSong Song
+		ticker := time.NewTicker(queuePollInterval)
+	playing:
+		for {
+			// The queue can change while the track plays, keep the lined
+			// up track in line with its head.
+			head, ok := peekSong(song.Guild)
+			if ok && (next == nil || head != nextSong) {
+				src, err := newSongSource(head)
+				if err != nil {
+					log.Println("Error starting ffmpeg,", err)
+				} else {
+					next, nextSong = mixer.QueueMusic(src), head
+				}
+			} else if !ok && next != nil {
+				mixer.ClearNext()
+				next = nil
+			}
+
+			select {
+			case <-track.done:
+				break playing
+			case <-ticker.C:
+			}
+		}
+		ticker.Stop()
+		clearCurrentSong(song.Guild, song.Link)
+
+		if next != nil && mixer.Current() == next {
+			removeSong(nextSong)
+			song, track = nextSong, next
+			continue
+		}
+		if !track.ended {
+			return
+		}
+		// Nothing was lined up in time, start the next song the slow way.
+		head, ok := popSong(song.Guild)
+		if !ok {
+			return
+		}
+		song = head
+		src, err := newSongSource(song)
+		if err != nil {
+			log.Println("Error starting ffmpeg,", err)
+			return
+		}
+		track = mixer.StartMusic(src)
+	}
+}
+
+func guildCrossfade(guild string) time.Duration {
+	crossfadeMutex.Lock()
+	defer crossfadeMutex.Unlock()
+	return time.Duration(crossfadeGuilds[guild]) * time.Second
+}
+
+func setCrossfade(ctx *Context) {
+	if !ctx.Has("seconds") {
+		fade := guildCrossfade(ctx.GuildID)
+		if fade == 0 {
+			ctx.Say("crossfade.off")
+			return
+		}
+		ctx.Say("crossfade.current", fade)
+		return
+	}
+
+	seconds := ctx.Int("seconds")
+	crossfadeMutex.Lock()
+	if seconds == 0 {
+		delete(crossfadeGuilds, ctx.GuildID)
+	} else {
+		crossfadeGuilds[ctx.GuildID] = seconds
+	}
+	err := saveData("crossfade", crossfadeGuilds)
+	crossfadeMutex.Unlock()
+	if err != nil {
+		log.Println("Error saving crossfade settings,", err)
+	}
+
+	voice, _ := findVoiceConnection(ctx.GuildID, "")
+	if voice.Mixer != nil {
+		voice.Mixer.SetCrossfade(time.Duration(seconds) * time.Second)
+	}
+	if seconds == 0 {
+		ctx.Say("crossfade.turned_off")
+		return
+	}
+	ctx.Say("crossfade.set", seconds)
+}
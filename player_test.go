@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestLeaveDuringPlayback(t *testing.T) {
+	oldVoice, oldQueue, oldStart := voiceConnections, queue, startQueue
+	defer func() { voiceConnections, queue, startQueue = oldVoice, oldQueue, oldStart }()
+
+	newTestMixer := func() *Mixer { return &Mixer{wake: make(chan struct{}, 1), quit: make(chan struct{})} }
+	voiceConnections = []Voice{{Guild: "g1", Mixer: newTestMixer()}, {Guild: "g2", Mixer: newTestMixer()}}
+	queue = nil
+	startQueue = func(mixer *Mixer, song Song) {
+		// Asked for while the first song plays, so it waits in the queue.
+		playAudioFile(Song{Link: "second", Guild: "g1"})
+		// Leaving rebuilds voiceConnections under the playing song.
+		if err := disconnectFromGuild("g1"); err != nil {
+			t.Error(err)
+		}
+	}
+	playAudioFile(Song{Link: "first", Guild: "g1"})
+
+	if songs := guildQueue("g1"); len(songs) != 1 || songs[0].Link != "second" {
+		t.Errorf("queue = %v, want the second song", songs)
+	}
+	if !startPlaying("g1") {
+		t.Error("player is still busy after the song ended")
+	}
+	stopPlaying("g1")
+	if len(voiceConnections) != 1 || voiceConnections[0].Guild != "g2" {
+		t.Errorf("voice connections = %+v, want only g2's", voiceConnections)
+	}
+}
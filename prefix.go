@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	maxPrefixLength  = 10
+	mentionPrefixTip = "@DMasik"
+)
+
+var (
+	defaultPrefixes = []string{"."}
+	maxPrefixes     = 5
+
+	// Guilds without an entry use defaultPrefixes.
+	guildPrefixes = map[string][]string{}
+	prefixMutex   sync.Mutex
+)
+
+// prefixesFor returns the prefixes commands start with in guild.
+func prefixesFor(guild string) []string {
+	prefixMutex.Lock()
+	defer prefixMutex.Unlock()
+	if prefixes, ok := guildPrefixes[guild]; ok {
+		return prefixes
+	}
+	return defaultPrefixes
+}
+
+// isCommand reports whether content starts with one of prefixes, or
+// mentions the bot first, and returns the rest of it. Letters in prefixes
+// match regardless of case.
+func isCommand(content string, prefixes []string, botID string) (bool, string) {
+	if botID != "" {
+		for _, mention := range []string{"<@" + botID + ">", "<@!" + botID + ">"} {
+			if strings.HasPrefix(content, mention) {
+				return true, strings.TrimSpace(content[len(mention):])
+			}
+		}
+	}
+
+	// "!!" has to be tried before "!" or it would never match.
+	sorted := append([]string{}, prefixes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, prefix := range sorted {
+		if rest, ok := cutPrefixFold(content, prefix); ok {
+			return true, rest
+		}
+	}
+	return false, ""
+}
+
+// cutPrefixFold removes prefix from s, comparing rune by rune with case
+// folding. Folded runes can differ in byte length, so s is cut where its
+// own runes end.
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	if prefix == "" {
+		return "", false
+	}
+	i := 0
+	for _, want := range prefix {
+		if i >= len(s) {
+			return "", false
+		}
+		got, size := utf8.DecodeRuneInString(s[i:])
+		if got != want && !strings.EqualFold(string(got), string(want)) {
+			return "", false
+		}
+		i += size
+	}
+	return s[i:], true
+}
+
+func showPrefixes(ctx *Context) {
+	ctx.Say("prefix.current", strings.Join(prefixesFor(ctx.GuildID), " "), mentionPrefixTip)
+}
+
+func setPrefixes(ctx *Context) {
+	prefixes := strings.Fields(ctx.String("prefixes"))
+	if len(prefixes) > maxPrefixes {
+		ctx.Say("prefix.too_many", maxPrefixes)
+		return
+	}
+	for _, prefix := range prefixes {
+		if utf8.RuneCountInString(prefix) > maxPrefixLength {
+			ctx.Say("prefix.too_long", maxPrefixLength, prefix)
+			return
+		}
+		if strings.HasPrefix(prefix, "<") {
+			ctx.Say("prefix.mention")
+			return
+		}
+	}
+	savePrefixes(ctx, prefixes)
+}
+
+func resetPrefixes(ctx *Context) {
+	savePrefixes(ctx, nil)
+}
+
+// savePrefixes stores prefixes for the guild, nil goes back to the
+// default.
+func savePrefixes(ctx *Context, prefixes []string) {
+	prefixMutex.Lock()
+	if prefixes == nil {
+		delete(guildPrefixes, ctx.GuildID)
+	} else {
+		guildPrefixes[ctx.GuildID] = prefixes
+	}
+	err := saveData("prefixes", guildPrefixes)
+	prefixMutex.Unlock()
+	if err != nil {
+		log.Println("Error saving prefixes,", err)
+		ctx.Say("prefix.save_failed")
+		return
+	}
+	showPrefixes(ctx)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestIsCommand(t *testing.T) {
+	const botID = "123456789012345678"
+	tests := []struct {
+		content  string
+		prefixes []string
+		want     bool
+		command  string
+	}{
+		{"", []string{"."}, false, ""},
+		{"h", []string{"!!"}, false, ""},
+		{"!", []string{"!!"}, false, ""},
+		{".", []string{"."}, true, ""},
+		{".help", []string{"."}, true, "help"},
+		{"help", []string{"."}, false, ""},
+		{"д", []string{"д!"}, false, ""},
+		{"д!play", []string{"д!"}, true, "play"},
+		{"Д!play", []string{"д!"}, true, "play"},
+		{"да", []string{"д!"}, false, ""},
+		{"ё", []string{"е"}, false, ""},
+		{"🎵 np", []string{"🎵"}, true, " np"},
+		{"🎶 np", []string{"🎵"}, false, ""},
+		{"DM!skip", []string{"dm!"}, true, "skip"},
+		{"!!skip", []string{"!", "!!"}, true, "skip"},
+		{"!skip", []string{"!", "!!"}, true, "skip"},
+		{"\xff.help", []string{"."}, false, ""},
+		{".help", nil, false, ""},
+		{".help", []string{""}, false, ""},
+		{"<@123456789012345678> help", []string{"."}, true, "help"},
+		{"<@!123456789012345678>help", []string{"."}, true, "help"},
+		{"<@123456789012345678>", []string{"."}, true, ""},
+		{"<@987654321098765432> help", []string{"."}, false, ""},
+		{"<@12345678901234567", []string{"."}, false, ""},
+	}
+	for _, tt := range tests {
+		got, command := isCommand(tt.content, tt.prefixes, botID)
+		if got != tt.want || command != tt.command {
+			t.Errorf("isCommand(%q, %q) = %v, %q, want %v, %q", tt.content, tt.prefixes, got, command, tt.want, tt.command)
+		}
+	}
+}
+
+func TestCutPrefixFold(t *testing.T) {
+	// The Kelvin sign folds to k but takes three bytes, the rest of the
+	// message must still start right after it.
+	rest, ok := cutPrefixFold("\u212Aplay", "k")
+	if !ok || rest != "play" {
+		t.Errorf("cutPrefixFold(Kelvin sign) = %q, %v, want \"play\", true", rest, ok)
+	}
+}
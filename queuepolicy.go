@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// musicPolicy limits what a guild's queue accepts, zero means no limit.
+// With Fair set requesters take turns instead of first come first served.
+type musicPolicy struct {
+	MaxLength  int  `json:"max_length"` // minutes
+	MaxPerUser int  `json:"max_per_user"`
+	MaxQueue   int  `json:"max_queue"`
+	Fair       bool `json:"fair"`
+}
+
+var (
+	musicPolicies      = map[string]*musicPolicy{}
+	musicPoliciesMutex sync.Mutex
+)
+
+func guildMusicPolicy(guild string) musicPolicy {
+	musicPoliciesMutex.Lock()
+	defer musicPoliciesMutex.Unlock()
+	if policy, ok := musicPolicies[guild]; ok {
+		return *policy
+	}
+	return musicPolicy{}
+}
+
+func updateMusicPolicy(guild string, update func(*musicPolicy)) error {
+	musicPoliciesMutex.Lock()
+	defer musicPoliciesMutex.Unlock()
+	policy, ok := musicPolicies[guild]
+	if !ok {
+		policy = &musicPolicy{}
+		musicPolicies[guild] = policy
+	}
+	update(policy)
+	return saveData("musicconfig", musicPolicies)
+}
+
+// checkSongRequest tells the requester why song can't be played, if it
+// breaks one of the guild's limits.
+func checkSongRequest(ctx *Context, song Song) bool {
+	policy := guildMusicPolicy(song.Guild)
+
+	queueMutex.Lock()
+	total, mine := 0, 0
+	for _, queued := range queue {
+		if queued.Guild == song.Guild {
+			total++
+			if queued.Requester == song.Requester {
+				mine++
+			}
+		}
+	}
+	queueMutex.Unlock()
+
+	if policy.MaxQueue > 0 && total >= policy.MaxQueue {
+		ctx.Say("queue.full", policy.MaxQueue)
+		return false
+	}
+	if policy.MaxPerUser > 0 && mine >= policy.MaxPerUser {
+		ctx.Say("queue.too_many", mine)
+		return false
+	}
+	if policy.MaxLength > 0 {
+		length, err := probeDuration(song.Link)
+		if err != nil {
+			log.Println("Error probing", song.Link, err)
+		} else if limit := time.Duration(policy.MaxLength) * time.Minute; length > limit {
+			ctx.Say("queue.too_long", formatTrackLength(length), formatTrackLength(limit))
+			return false
+		}
+	}
+	return true
+}
+
+// requestSong plays file for the message author, or queues it behind the
+// current song, if the guild's limits allow it.
+func requestSong(ctx *Context, file string, guild string, channel string, linkType string) {
+	song := Song{Link: file, Type: linkType, Guild: guild, Channel: channel, Requester: ctx.Author.ID}
+	if !checkSongRequest(ctx, song) {
+		return
+	}
+	goSafe(func() { playAudioFile(song) })
+}
+
+// fairInsert returns where song goes in the queue when requesters take
+// turns: every requester's n-th song plays after everyone's (n-1)-th one.
+func fairInsert(queue []Song, song Song) int {
+	at := len(queue)
+	seen := map[string]int{}
+	rounds := make([]int, len(queue))
+	for i, queued := range queue {
+		if queued.Guild == song.Guild {
+			rounds[i] = seen[queued.Requester]
+			seen[queued.Requester]++
+		}
+	}
+	round := seen[song.Requester]
+	for i := len(queue) - 1; i >= 0; i-- {
+		if queue[i].Guild != song.Guild {
+			continue
+		}
+		if rounds[i] <= round {
+			return i + 1
+		}
+		at = i
+	}
+	return at
+}
+
+func showMusicConfig(ctx *Context) {
+	policy := guildMusicPolicy(ctx.GuildID)
+	limit := func(n int, key string) string {
+		if n == 0 {
+			return ctx.T("music.unlimited")
+		}
+		return ctx.T(key, n)
+	}
+	order := ctx.T("music.order_fifo")
+	if policy.Fair {
+		order = ctx.T("music.order_fair")
+	}
+	ctx.Say("music.settings", limit(policy.MaxLength, "music.minutes"), limit(policy.MaxPerUser, "music.songs"),
+		limit(policy.MaxQueue, "music.songs"), order)
+}
+
+func setMaxSongLength(ctx *Context) {
+	configureMusic(ctx, func(policy *musicPolicy) { policy.MaxLength = ctx.Int("minutes") })
+}
+
+func setMaxSongsPerUser(ctx *Context) {
+	configureMusic(ctx, func(policy *musicPolicy) { policy.MaxPerUser = ctx.Int("songs") })
+}
+
+func setMaxQueueLength(ctx *Context) {
+	configureMusic(ctx, func(policy *musicPolicy) { policy.MaxQueue = ctx.Int("songs") })
+}
+
+func setFairQueue(ctx *Context) {
+	configureMusic(ctx, func(policy *musicPolicy) { policy.Fair = ctx.Bool("state") })
+}
+
+func configureMusic(ctx *Context, update func(*musicPolicy)) {
+	err := updateMusicPolicy(ctx.GuildID, update)
+	if err != nil {
+		log.Println("Error saving music settings,", err)
+		ctx.Say("music.save_failed")
+		return
+	}
+	ctx.Say("music.updated")
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	quizSnippetLength  = 15 * time.Second
+	quizRoundTime      = 25 * time.Second
+	quizRoundPause     = 3 * time.Second
+	quizDefaultRounds  = 5
+	quizMaxRounds      = 20
+	quizMatchThreshold = 0.8
+	// Only quizzes of at least this many rounds, played to the end, pay
+	// rewards, so short ones can't be repeated for coins.
+	quizRewardRounds = quizDefaultRounds
+)
+
+// quizRewards are the coins paid to the first, second and third place.
+var quizRewards = []int{100, 50, 25}
+
+type quizGame struct {
+	GuildID   string
+	ChannelID string
+
+	tracks  []libraryTrack
+	scores  map[string]int
+	names   map[string]string
+	guesses chan *discordgo.MessageCreate
+	stop    chan struct{}
+	once    sync.Once
+}
+
+var (
+	quizGames = map[string]*quizGame{}
+	quizMutex sync.Mutex
+)
+
+func stopQuiz(ctx *Context) {
+	quizMutex.Lock()
+	game, ok := quizGames[ctx.GuildID]
+	quizMutex.Unlock()
+	if !ok {
+		ctx.Say("quiz.not_running")
+		return
+	}
+	game.once.Do(func() { close(game.stop) })
+}
+
+func startQuiz(ctx *Context) {
+	rounds := quizDefaultRounds
+	if ctx.Has("rounds") {
+		rounds = ctx.Int("rounds")
+	}
+	category := ctx.String("category")
+
+	voice, _ := findVoiceConnection(ctx.GuildID, "")
+	if voice.Mixer == nil {
+		ctx.Say("quiz.needs_voice")
+		return
+	}
+
+	library, err := scanLibrary()
+	if err != nil {
+		log.Println(err)
+	}
+	var tracks []libraryTrack
+	for _, track := range library {
+		if category == "" || strings.EqualFold(track.Category, category) {
+			tracks = append(tracks, track)
+		}
+	}
+	if len(tracks) == 0 {
+		ctx.Say("quiz.no_music")
+		return
+	}
+	rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+	if rounds > len(tracks) {
+		rounds = len(tracks)
+	}
+
+	game := &quizGame{
+		GuildID:   ctx.GuildID,
+		ChannelID: ctx.ChannelID,
+		tracks:    tracks[:rounds],
+		scores:    map[string]int{},
+		names:     map[string]string{},
+		guesses:   make(chan *discordgo.MessageCreate, 16),
+		stop:      make(chan struct{}),
+	}
+	quizMutex.Lock()
+	if _, running := quizGames[ctx.GuildID]; running {
+		quizMutex.Unlock()
+		ctx.Say("quiz.running")
+		return
+	}
+	quizGames[ctx.GuildID] = game
+	quizMutex.Unlock()
+
+	goSafe(func() { game.run(ctx.Session) })
+}
+
+// quizRunning reports whether guild has a quiz going, while it does the
+// library isn't listed so the answers can't be looked up.
+func quizRunning(guild string) bool {
+	quizMutex.Lock()
+	defer quizMutex.Unlock()
+	_, ok := quizGames[guild]
+	return ok
+}
+
+// quizGuess hands a chat message to the quiz running in its channel.
+func quizGuess(m *discordgo.MessageCreate) {
+	quizMutex.Lock()
+	game, ok := quizGames[m.GuildID]
+	quizMutex.Unlock()
+	if !ok || game.ChannelID != m.ChannelID {
+		return
+	}
+	select {
+	case game.guesses <- m:
+	default:
+	}
+}
+
+func (g *quizGame) run(s *discordgo.Session) {
+	defer func() {
+		quizMutex.Lock()
+		delete(quizGames, g.GuildID)
+		quizMutex.Unlock()
+	}()
+
+	// The queue waits for the quiz instead of being stopped by it.
+	pauseMusic(g.GuildID, true)
+	defer pauseMusic(g.GuildID, false)
+
+	g.say(s, "quiz.start", len(g.tracks))
+	completed := true
+	for round, track := range g.tracks {
+		g.say(s, "quiz.round", round+1, len(g.tracks))
+		stopSnippet := g.playSnippet(track)
+
+		winner, elapsed, stopped := g.waitForAnswer(track)
+		stopSnippet()
+		if stopped {
+			completed = false
+			break
+		}
+
+		answer := track.Title
+		if track.Artist != "" {
+			answer = track.Artist + " - " + track.Title
+		}
+		if winner == nil {
+			g.say(s, "quiz.timeout", answer)
+		} else {
+			points := quizPoints(elapsed)
+			g.scores[winner.ID] += points
+			g.names[winner.ID] = winner.Username
+			g.say(s, "quiz.correct", points, winner.Username, elapsed.Seconds(), answer)
+		}
+
+		if round+1 < len(g.tracks) {
+			select {
+			case <-time.After(quizRoundPause):
+			case <-g.stop:
+			}
+		}
+	}
+	g.finish(s, completed && len(g.tracks) >= quizRewardRounds)
+}
+
+// say posts a line of the game to its channel.
+func (g *quizGame) say(s *discordgo.Session, key string, args ...interface{}) {
+	_, err := sendMessage(s, g.ChannelID, tr(g.GuildID, key, args...))
+	if err != nil {
+		log.Println("Error sending quiz message,", err)
+	}
+}
+
+// playSnippet plays a part of track over the paused music and returns
+// what stops it.
+func (g *quizGame) playSnippet(track libraryTrack) func() {
+	voice, _ := findVoiceConnection(g.GuildID, "")
+	if voice.Mixer == nil {
+		log.Println("No voice connection in guild", g.GuildID)
+		return func() {}
+	}
+	// The bot may have rejoined since the quiz started.
+	voice.Mixer.SetPaused(true)
+	src, err := newFFmpegSource(track.Path, ffmpegOptions{
+		Start:    quizSnippetStart(track),
+		Duration: quizSnippetLength,
+		Gain:     normalizationGain(track.Path),
+	})
+	if err != nil {
+		log.Println("Error starting ffmpeg,", err)
+		return func() {}
+	}
+	voice.Mixer.Overlay(src)
+	return func() { voice.Mixer.StopOverlay(src) }
+}
+
+func (g *quizGame) waitForAnswer(track libraryTrack) (*discordgo.User, time.Duration, bool) {
+	start := time.Now()
+	timeout := time.After(quizRoundTime)
+	for {
+		select {
+		case m := <-g.guesses:
+			if quizAnswerMatches(m.Content, track) {
+				return m.Author, time.Since(start), false
+			}
+		case <-timeout:
+			return nil, 0, false
+		case <-g.stop:
+			return nil, 0, true
+		}
+	}
+}
+
+// finish posts the scores, with rewards for the top three when paid.
+func (g *quizGame) finish(s *discordgo.Session, paid bool) {
+	type entry struct {
+		UserID string
+		Score  int
+	}
+	var entries []entry
+	for userID, score := range g.scores {
+		entries = append(entries, entry{userID, score})
+	}
+	if len(entries) == 0 {
+		g.say(s, "quiz.nobody_scored")
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Score > entries[j].Score })
+
+	var board strings.Builder
+	for i, e := range entries {
+		board.WriteString(tr(g.GuildID, "quiz.score", e.Score, i+1, g.names[e.UserID]))
+		if paid && i < len(quizRewards) {
+			err := addToWallet(g.GuildID, e.UserID, quizRewards[i])
+			if err != nil {
+				log.Println("Error paying quiz reward,", err)
+			} else {
+				board.WriteString(" " + tr(g.GuildID, "quiz.reward", quizRewards[i]))
+			}
+		}
+		board.WriteString("\n")
+	}
+	if !paid {
+		board.WriteString("\n" + tr(g.GuildID, "quiz.no_reward", quizRewardRounds))
+	}
+	err := channelReplier{s, g.ChannelID}.ReplyEmbed(newEmbed(tr(g.GuildID, "quiz.results")).Description(board.String()).Build(g.GuildID))
+	if err != nil {
+		log.Println("Error sending quiz results,", err)
+	}
+}
+
+func quizAnswerMatches(guess string, track libraryTrack) bool {
+	if similarity(guess, track.Title) >= quizMatchThreshold {
+		return true
+	}
+	return track.Artist != "" && similarity(guess, track.Artist) >= quizMatchThreshold
+}
+
+// quizPoints gives 100 for a right answer plus up to 100 more the faster
+// it came.
+func quizPoints(elapsed time.Duration) int {
+	bonus := int(100 * (1 - elapsed.Seconds()/quizRoundTime.Seconds()))
+	if bonus < 0 {
+		bonus = 0
+	}
+	return 100 + bonus
+}
+
+func quizSnippetStart(track libraryTrack) time.Duration {
+	duration, err := probeDuration(track.Path)
+	if err != nil || duration <= quizSnippetLength {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(duration - quizSnippetLength)))
+}
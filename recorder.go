@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"layeh.com/gopus"
+)
+
+const (
+	recordBufferSeconds = 60
+	recordFrameDuration = 20 * time.Millisecond
+	recordBufferFrames  = recordBufferSeconds * int(time.Second/recordFrameDuration)
+	clipDefaultDuration = 30 * time.Second
+
+	// A speaker whose RTP clock lands further than this from the wall
+	// clock is anchored again, after a client paused its clock through
+	// silence or an SSRC got reused.
+	recordResyncThreshold = time.Second
+)
+
+var (
+	soundboardPath = "./audio"
+
+	recordingGuilds = map[string]bool{}
+	recordingMutex  sync.Mutex
+
+	clipNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+)
+
+type recordedFrame struct {
+	At  time.Time
+	PCM []int16
+}
+
+// speakerBuffer is a ring buffer holding the last recordBufferSeconds of one
+// speaker's decoded audio.
+type speakerBuffer struct {
+	decoder *gopus.Decoder
+	frames  []recordedFrame
+	next    int
+
+	// anchor is the wall clock time of the RTP timestamp anchorStamp.
+	// Frames are placed by their RTP timestamp from there, so bursts and
+	// jitter on the way in don't move them.
+	anchor       time.Time
+	anchorStamp  uint32
+	lastSequence uint16
+	started      bool
+}
+
+// place returns when a packet was spoken, going by its RTP timestamp, and
+// false for duplicates and packets arriving after later ones, which the
+// decoder can't take anymore.
+func (b *speakerBuffer) place(sequence uint16, timestamp uint32, now time.Time) (time.Time, bool) {
+	if b.started && int16(sequence-b.lastSequence) <= 0 {
+		return time.Time{}, false
+	}
+	at := b.anchor.Add(time.Duration(int32(timestamp-b.anchorStamp)) * time.Second / time.Duration(audioFrameRate))
+	if !b.started || at.Sub(now) > recordResyncThreshold || now.Sub(at) > recordResyncThreshold {
+		b.anchor, b.anchorStamp, at = now, timestamp, now
+	}
+	b.started, b.lastSequence = true, sequence
+	return at, true
+}
+
+func (b *speakerBuffer) push(frame recordedFrame) {
+	if len(b.frames) < recordBufferFrames {
+		b.frames = append(b.frames, frame)
+		return
+	}
+	b.frames[b.next] = frame
+	b.next = (b.next + 1) % recordBufferFrames
+}
+
+// Recorder keeps a rolling buffer of everything said in a voice channel,
+// one speakerBuffer per SSRC.
+type Recorder struct {
+	vc *discordgo.VoiceConnection
+
+	mu       sync.Mutex
+	speakers map[uint32]*speakerBuffer
+
+	quit chan struct{}
+	once sync.Once
+}
+
+func newRecorder(vc *discordgo.VoiceConnection) *Recorder {
+	recorder := &Recorder{
+		vc:       vc,
+		speakers: map[uint32]*speakerBuffer{},
+		quit:     make(chan struct{}),
+	}
+	go recorder.run()
+	return recorder
+}
+
+func (r *Recorder) run() {
+	for {
+		recv, ok := r.waitReady()
+		if !ok {
+			return
+		}
+
+		var packet *discordgo.Packet
+		select {
+		case packet, ok = <-recv:
+			if !ok {
+				return
+			}
+		case <-r.quit:
+			return
+		}
+
+		r.mu.Lock()
+		speaker, ok := r.speakers[packet.SSRC]
+		if !ok {
+			decoder, err := gopus.NewDecoder(audioFrameRate, audioChannels)
+			if err != nil {
+				r.mu.Unlock()
+				log.Println("Error creating opus decoder,", err)
+				continue
+			}
+			speaker = &speakerBuffer{decoder: decoder}
+			r.speakers[packet.SSRC] = speaker
+		}
+		at, ok := speaker.place(packet.Sequence, packet.Timestamp, time.Now())
+		if ok {
+			pcm, err := speaker.decoder.Decode(packet.Opus, audioFrameSize, false)
+			if err == nil {
+				speaker.push(recordedFrame{At: at, PCM: pcm})
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// waitReady blocks while the voice connection is down and returns the
+// channel packets arrive on once it is up. discordgo swaps both during a
+// reconnect under the connection's lock. It is false when the recorder
+// was closed meanwhile.
+func (r *Recorder) waitReady() (chan *discordgo.Packet, bool) {
+	for {
+		r.vc.RLock()
+		ready, recv := r.vc.Ready, r.vc.OpusRecv
+		r.vc.RUnlock()
+		if ready && recv != nil {
+			return recv, true
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-r.quit:
+			return nil, false
+		}
+	}
+}
+
+func (r *Recorder) Close() {
+	r.once.Do(func() { close(r.quit) })
+}
+
+// Mixdown places every speaker's frames on a shared timeline covering the
+// last d and sums them into one stereo PCM stream.
+func (r *Recorder) Mixdown(d time.Duration) []int16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return mixdown(r.speakers, time.Now().Add(-d), d)
+}
+
+// mixdown sums the speakers' frames from start to start+d, each at the
+// sample its time falls on.
+func mixdown(speakers map[uint32]*speakerBuffer, start time.Time, d time.Duration) []int16 {
+	samples := int(int64(d) * int64(audioFrameRate) / int64(time.Second))
+	sum := make([]int32, samples*audioChannels)
+	for _, speaker := range speakers {
+		for _, frame := range speaker.frames {
+			offset := int(int64(frame.At.Sub(start))*int64(audioFrameRate)/int64(time.Second)) * audioChannels
+			for i, sample := range frame.PCM {
+				if offset+i >= 0 && offset+i < len(sum) {
+					sum[offset+i] += int32(sample)
+				}
+			}
+		}
+	}
+	return clampFrame(sum)
+}
+
+func guildRecordingEnabled(guildID string) bool {
+	if !config.Recording {
+		return false
+	}
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	return recordingGuilds[guildID]
+}
+
+func showRecording(ctx *Context) {
+	if guildRecordingEnabled(ctx.GuildID) {
+		ctx.Say("recording.is_on")
+	} else {
+		ctx.Say("recording.is_off")
+	}
+}
+
+func toggleRecording(ctx *Context) {
+	if !config.Recording {
+		ctx.Say("recording.disabled")
+		return
+	}
+	enabled := ctx.Command.Name == "on"
+	recordingMutex.Lock()
+	recordingGuilds[ctx.GuildID] = enabled
+	err := saveData("recording", recordingGuilds)
+	recordingMutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		ctx.Say("recording.save_failed")
+		return
+	}
+
+	if enabled {
+		ctx.Say("recording.turned_on", recordBufferSeconds)
+	} else {
+		ctx.Say("recording.turned_off")
+	}
+	if setLiveRecording(ctx.GuildID, enabled) && enabled {
+		announceRecording(ctx)
+	}
+}
+
+// setLiveRecording starts or stops the recorder on the guild's current
+// voice connection and undeafens or deafens the bot to match. It reports
+// whether the bot is in voice there.
+func setLiveRecording(guild string, enabled bool) bool {
+	voice, index := findVoiceConnection(guild, "")
+	if voice.VoiceConnection == nil {
+		return false
+	}
+	if voice.Recorder != nil {
+		voice.Recorder.Close()
+		voiceConnections[index].Recorder = nil
+	}
+	err := voice.VoiceConnection.ChangeChannel(voice.Channel, false, !enabled)
+	if err != nil {
+		log.Println("Error changing voice state,", err)
+	}
+	if enabled {
+		voiceConnections[index].Recorder = newRecorder(voice.VoiceConnection)
+	}
+	return true
+}
+
+// announceRecording tells the channel that voice is being recorded whenever
+// the bot joins voice in a guild that opted in.
+func announceRecording(ctx *Context) {
+	if guildRecordingEnabled(ctx.GuildID) {
+		ctx.Say("recording.announce", recordBufferSeconds)
+	}
+}
+
+// announceRecordingIn is announceRecording for joins no command asked
+// for, like scheduled events.
+func announceRecordingIn(s *discordgo.Session, guild string, channel string) {
+	if guildRecordingEnabled(guild) {
+		_, err := sendMessage(s, channel, tr(guild, "recording.announce", recordBufferSeconds))
+		if err != nil {
+			log.Println("Error announcing recording,", err)
+		}
+	}
+}
+
+func clipThat(ctx *Context) {
+	voice, _ := findVoiceConnection(ctx.GuildID, "")
+	if voice.Recorder == nil {
+		ctx.Say("clip.not_recording")
+		return
+	}
+
+	duration := clipDefaultDuration
+	if ctx.Has("duration") {
+		duration = ctx.Duration("duration")
+	}
+	if duration > recordBufferSeconds*time.Second {
+		duration = recordBufferSeconds * time.Second
+	}
+
+	saveName := ctx.String("name")
+	if ctx.Has("save") != ctx.Has("name") {
+		ctx.UsageError(&ArgError{Err: localErr("clip.save_usage")})
+		return
+	}
+	if saveName != "" && !clipNamePattern.MatchString(saveName) {
+		ctx.UsageError(&ArgError{Arg: "name", Err: localErr("args.name_chars")})
+		return
+	}
+
+	ogg, err := encodeOgg(voice.Recorder.Mixdown(duration))
+	if err != nil {
+		log.Println("Error encoding clip,", err)
+		ctx.Say("clip.encode_failed")
+		return
+	}
+
+	if saveName != "" {
+		path := filepath.Join(soundboardPath, saveName+".ogg")
+		if _, err := os.Stat(path); err == nil {
+			ctx.Say("clip.exists", saveName)
+			return
+		}
+		err = ioutil.WriteFile(path, ogg, 0644)
+		if err != nil {
+			log.Println("Error saving clip,", err)
+			ctx.Say("clip.save_failed")
+			return
+		}
+		goSafe(func() {
+			err := analyzeTrack(path)
+			if err != nil {
+				log.Println("Error analyzing clip,", err)
+			}
+		})
+	}
+
+	ctx.ReplyMessage(&discordgo.MessageSend{
+		Files: []*discordgo.File{{Name: "clip.ogg", ContentType: "audio/ogg", Reader: bytes.NewReader(ogg)}},
+	})
+}
+
+func encodeOgg(pcm []int16) ([]byte, error) {
+	var in, out bytes.Buffer
+	err := binary.Write(&in, binary.LittleEndian, pcm)
+	if err != nil {
+		return nil, err
+	}
+	run := exec.Command("ffmpeg", "-f", "s16le", "-ar", strconv.Itoa(audioFrameRate), "-ac", strconv.Itoa(audioChannels), "-i", "pipe:0", "-c:a", "libopus", "-f", "ogg", "pipe:1")
+	run.Stdin = &in
+	run.Stdout = &out
+	err = run.Run()
+	return out.Bytes(), err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSpeakerPlace(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var b speakerBuffer
+
+	// The first packet anchors the speaker, the next ones arrive in a
+	// burst but are placed 20ms apart by their RTP timestamps.
+	tests := []struct {
+		sequence  uint16
+		timestamp uint32
+		arrived   time.Duration
+		want      time.Duration
+		ok        bool
+	}{
+		{65534, 4294966000, 0, 0, true},
+		{65535, 4294966960, 5 * time.Millisecond, 20 * time.Millisecond, true},
+		{0, 624, 5 * time.Millisecond, 40 * time.Millisecond, true},
+		{0, 624, 6 * time.Millisecond, 0, false},
+		{65535, 4294966960, 7 * time.Millisecond, 0, false},
+		// A lost packet leaves a gap instead of pulling the rest forward.
+		{2, 2544, 70 * time.Millisecond, 80 * time.Millisecond, true},
+		// A clock that stood still through silence is anchored again.
+		{3, 3504, 10 * time.Second, 10 * time.Second, true},
+		{4, 4464, 10 * time.Second, 10*time.Second + 20*time.Millisecond, true},
+	}
+	for i, tt := range tests {
+		at, ok := b.place(tt.sequence, tt.timestamp, start.Add(tt.arrived))
+		if ok != tt.ok || ok && !at.Equal(start.Add(tt.want)) {
+			t.Errorf("packet %d placed at %v, %v, want %v, %v", i, at.Sub(start), ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMixdown(t *testing.T) {
+	start := time.Unix(1000, 0)
+	frame := func(at time.Duration, value int16) recordedFrame {
+		pcm := make([]int16, audioFrameSize*audioChannels)
+		for i := range pcm {
+			pcm[i] = value
+		}
+		return recordedFrame{At: start.Add(at), PCM: pcm}
+	}
+	speakers := map[uint32]*speakerBuffer{
+		1: {frames: []recordedFrame{frame(0, 1), frame(20*time.Millisecond, 2), frame(-20*time.Millisecond, 50)}},
+		2: {frames: []recordedFrame{frame(10*time.Millisecond, 10), frame(60*time.Millisecond, 30000)}},
+		3: {frames: []recordedFrame{frame(60*time.Millisecond, 30000)}},
+	}
+
+	pcm := mixdown(speakers, start, 80*time.Millisecond)
+	if len(pcm) != 4*audioFrameSize*audioChannels {
+		t.Fatalf("got %d samples, want four frames", len(pcm))
+	}
+	half := audioFrameSize / 2 * audioChannels
+	for _, tt := range []struct {
+		at   int
+		want int16
+	}{
+		{0, 1},
+		{half - 1, 1},
+		{half, 11},
+		{2 * half, 12},
+		{3*half - 1, 12},
+		{3 * half, 2},
+		{4*half - 1, 2},
+		{4 * half, 0},
+		{6 * half, 32767},
+		{8*half - 1, 32767},
+	} {
+		if pcm[tt.at] != tt.want {
+			t.Errorf("sample %d = %d, want %d", tt.at, pcm[tt.at], tt.want)
+		}
+	}
+}
+
+func TestRecorderWaitsForVoice(t *testing.T) {
+	vc := &discordgo.VoiceConnection{}
+	r := newRecorder(vc)
+	defer r.Close()
+
+	// Connecting sets these under the lock while the recorder waits.
+	packets := make(chan *discordgo.Packet)
+	vc.Lock()
+	vc.Ready, vc.OpusRecv = true, packets
+	vc.Unlock()
+
+	select {
+	case packets <- &discordgo.Packet{SSRC: 7, Sequence: 1, Timestamp: 960}:
+	case <-time.After(time.Second):
+		t.Fatal("recorder didn't start reading once voice was ready")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		_, ok := r.speakers[7]
+		r.mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("packet wasn't taken in")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
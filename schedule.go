@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// cronSpec is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a bitset of allowed values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronFieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+func parseCron(expr string) (cronSpec, error) {
+	var spec cronSpec
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return spec, localErr("schedule.cron_fields")
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFieldRanges[i][0], cronFieldRanges[i][1])
+		if err != nil {
+			return spec, localErr("schedule.cron_field", i+1, field, err)
+		}
+		sets[i] = set
+	}
+	spec.minute, spec.hour, spec.dom, spec.month, spec.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	spec.domStar = fields[2] == "*"
+	spec.dowStar = fields[4] == "*"
+	return spec, nil
+}
+
+// parseCronField understands *, a, a-b, and a step after any of them
+// (*/15, 1-10/2), separated by commas.
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, localErr("schedule.cron_step")
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, localErr("schedule.cron_number")
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, localErr("schedule.cron_number")
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		// Sunday may be written as 7, on its own or ending a range.
+		limit := max
+		if max == 6 {
+			limit = 7
+		}
+		if lo < min || hi > limit || lo > hi {
+			return 0, localErr("schedule.cron_range", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v%(max+1))
+		}
+	}
+	return set, nil
+}
+
+func (c cronSpec) Matches(t time.Time) bool {
+	has := func(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	// Like cron, when both day fields are restricted either one may match.
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+type scheduledEvent struct {
+	ID             int    `json:"id"`
+	VoiceChannelID string `json:"voice_channel_id"`
+	TextChannelID  string `json:"text_channel_id"`
+	Cron           string `json:"cron"`
+	Timezone       string `json:"timezone"`
+	Target         string `json:"target"`
+	Leave          bool   `json:"leave"`
+	CreatedBy      string `json:"created_by"`
+}
+
+type guildSchedules struct {
+	NextID int               `json:"next_id"`
+	Events []*scheduledEvent `json:"events"`
+}
+
+var (
+	schedules     = map[string]*guildSchedules{}
+	scheduleMutex sync.Mutex
+)
+
+// startScheduler checks every schedule at the top of each minute.
+func startScheduler(s *discordgo.Session) {
+	go func() {
+		for {
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			runDueSchedules(s, time.Now())
+		}
+	}()
+}
+
+func runDueSchedules(s *discordgo.Session, now time.Time) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	for guildID, guild := range schedules {
+		for _, event := range guild.Events {
+			spec, err := parseCron(event.Cron)
+			if err != nil {
+				continue
+			}
+			location, err := time.LoadLocation(event.Timezone)
+			if err != nil {
+				continue
+			}
+			if spec.Matches(now.In(location)) {
+				go runScheduledEvent(s, guildID, *event)
+			}
+		}
+	}
+}
+
+// runScheduledEvent plays an event. It runs on its own goroutine, so a
+// panic is logged here rather than taking the bot down.
+func runScheduledEvent(s *discordgo.Session, guildID string, event scheduledEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in scheduled event %d in guild %s: %v\n%s", event.ID, guildID, r, debug.Stack())
+		}
+	}()
+	tracks, err := resolveScheduleTarget(event.Target)
+	if err != nil {
+		log.Println("Scheduled event", event.ID, "in guild", guildID, "failed,", err)
+		return
+	}
+
+	_, err = sendMessage(s, event.TextChannelID, tr(guildID, "schedule.playing", event.ID, event.Target))
+	if err != nil {
+		log.Println("Error announcing scheduled event,", err)
+	}
+	voice, _ := findVoiceConnection(guildID, "")
+	if voice.Mixer == nil || voice.Channel != event.VoiceChannelID {
+		joined, err := connectToVoiceChannel(s, guildID, event.VoiceChannelID)
+		if err != nil {
+			log.Println("Scheduled event", event.ID, "in guild", guildID, "couldn't join voice,", err)
+			return
+		}
+		voiceConnections = append(voiceConnections, joined)
+		announceRecordingIn(s, guildID, event.TextChannelID)
+	}
+	for _, track := range tracks {
+		playAudioSnippet(guildID, track.Path, 0, 0)
+	}
+	if event.Leave {
+		if err := disconnectFromGuild(guildID); err != nil {
+			log.Println("Scheduled event", event.ID, "in guild", guildID, "couldn't leave voice,", err)
+		}
+	}
+}
+
+// resolveScheduleTarget turns a schedule target into tracks: a library id,
+// a library folder played as a playlist, or a clip name like "bruh".
+func resolveScheduleTarget(target string) ([]libraryTrack, error) {
+	library, err := scanLibrary()
+	if err != nil {
+		return nil, err
+	}
+	if id, err := strconv.Atoi(target); err == nil {
+		if id < 1 || id > len(library) {
+			return nil, localErr("schedule.no_track", id)
+		}
+		return library[id-1 : id], nil
+	}
+
+	var folder []libraryTrack
+	for _, track := range library {
+		if strings.EqualFold(track.Category, target) {
+			folder = append(folder, track)
+		}
+	}
+	if len(folder) > 0 {
+		return folder, nil
+	}
+	for _, track := range library {
+		if strings.EqualFold(strings.TrimSuffix(track.Name, filepath.Ext(track.Name)), target) {
+			return []libraryTrack{track}, nil
+		}
+	}
+	return nil, localErr("schedule.no_target", target)
+}
+
+func addSchedule(ctx *Context) {
+	cron := strings.Join(ctx.Args[:5], " ")
+	if _, err := parseCron(cron); err != nil {
+		ctx.Say("schedule.bad_cron", ctx.Explain(err))
+		return
+	}
+	timezone, target := ctx.String("timezone"), ctx.String("target")
+	if _, err := time.LoadLocation(timezone); err != nil {
+		ctx.Say("schedule.bad_timezone", timezone)
+		return
+	}
+	voiceChannelID := ctx.String("voice channel")
+	channel, err := ctx.Session.State.Channel(voiceChannelID)
+	if err != nil || channel.GuildID != ctx.GuildID || channel.Type != discordgo.ChannelTypeGuildVoice {
+		ctx.Say("schedule.not_voice")
+		return
+	}
+	if _, err := resolveScheduleTarget(target); err != nil {
+		ctx.Say("schedule.bad_target", ctx.Explain(err))
+		return
+	}
+
+	scheduleMutex.Lock()
+	guild, ok := schedules[ctx.GuildID]
+	if !ok {
+		guild = &guildSchedules{}
+		schedules[ctx.GuildID] = guild
+	}
+	guild.NextID++
+	event := &scheduledEvent{
+		ID:             guild.NextID,
+		VoiceChannelID: voiceChannelID,
+		TextChannelID:  ctx.ChannelID,
+		Cron:           cron,
+		Timezone:       timezone,
+		Target:         target,
+		Leave:          ctx.Has("leave"),
+		CreatedBy:      ctx.Author.ID,
+	}
+	guild.Events = append(guild.Events, event)
+	err = saveData("schedules", schedules)
+	scheduleMutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		ctx.Say("schedule.save_failed")
+		return
+	}
+	ctx.Say("schedule.added", event.ID, event.Target, event.Cron, event.Timezone, event.VoiceChannelID)
+}
+
+func listSchedules(ctx *Context) {
+	var lines []string
+	scheduleMutex.Lock()
+	if guild, ok := schedules[ctx.GuildID]; ok {
+		for _, event := range guild.Events {
+			leave := ""
+			if event.Leave {
+				leave = ", " + ctx.T("schedule.then_leave")
+			}
+			lines = append(lines, fmt.Sprintf("#%d `%s` %s — %s in <#%s>%s", event.ID, event.Cron, event.Timezone, event.Target, event.VoiceChannelID, leave))
+		}
+	}
+	scheduleMutex.Unlock()
+
+	if len(lines) == 0 {
+		ctx.Say("schedule.none")
+		return
+	}
+	paginateLines(ctx, ctx.T("schedule.title"), lines)
+}
+
+func removeSchedule(ctx *Context) {
+	id := ctx.Int("id")
+
+	var err error
+	scheduleMutex.Lock()
+	removed := false
+	if guild, ok := schedules[ctx.GuildID]; ok {
+		for i, event := range guild.Events {
+			if event.ID == id {
+				guild.Events = append(guild.Events[:i], guild.Events[i+1:]...)
+				removed = true
+				break
+			}
+		}
+	}
+	if removed {
+		err = saveData("schedules", schedules)
+	}
+	scheduleMutex.Unlock()
+
+	switch {
+	case !removed:
+		ctx.Say("schedule.unknown", id)
+	case err != nil:
+		log.Println(err)
+		ctx.Say("schedule.remove_failed")
+	default:
+		ctx.Say("schedule.removed", id)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseCronField(t *testing.T) {
+	days := func(list ...int) uint64 {
+		var set uint64
+		for _, d := range list {
+			set |= 1 << uint(d)
+		}
+		return set
+	}
+	tests := []struct {
+		field   string
+		want    uint64
+		wantErr bool
+	}{
+		{"7", days(0), false},
+		{"0", days(0), false},
+		{"5-7", days(5, 6, 0), false},
+		{"*/2", days(0, 2, 4, 6), false},
+		{"1-7/3", days(1, 4, 0), false},
+		{"6,7", days(6, 0), false},
+		{"8", 0, true},
+		{"6-5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, 0, 6)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, %v, want %b", tt.field, got, err, tt.want)
+		}
+	}
+
+	// 7 is only Sunday, other fields stop at their maximum.
+	if _, err := parseCronField("24", 0, 23); err == nil {
+		t.Error("hour 24 was accepted")
+	}
+}
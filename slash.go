@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// Discord doesn't let a command with sub-commands run on its own, so
+	// the parent's Run gets a sub-command of its own with this name.
+	slashDefaultSubcommand = "show"
+
+	slashMaxText    = 100
+	slashMaxChoices = 25
+)
+
+// completer suggests values for an argument from what has been typed so
+// far in guild.
+type completer func(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice
+
+// slashCommands turns the registry into slash command definitions. Aliases
+// are left out, they would only crowd the command picker.
+func slashCommands() []*discordgo.ApplicationCommand {
+	var definitions []*discordgo.ApplicationCommand
+	for _, cmd := range commandList {
+		definitions = append(definitions, slashCommand(cmd))
+	}
+	return definitions
+}
+
+func slashCommand(cmd *Command) *discordgo.ApplicationCommand {
+	dm := false
+	descriptions := slashDescriptions([]string{cmd.Name})
+	definition := &discordgo.ApplicationCommand{
+		Name:                     cmd.Name,
+		Description:              truncateText(cmd.Description, slashMaxText),
+		DescriptionLocalizations: &descriptions,
+		DMPermission:             &dm,
+	}
+	if cmd.Level >= PermAdmin {
+		permissions := int64(discordgo.PermissionManageServer)
+		definition.DefaultMemberPermissions = &permissions
+	}
+	if len(cmd.Subcommands) == 0 {
+		definition.Options = slashOptions(cmd)
+		return definition
+	}
+
+	if cmd.Run != nil {
+		definition.Options = append(definition.Options, &discordgo.ApplicationCommandOption{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     slashDefaultSubcommand,
+			Description:              truncateText(cmd.Description, slashMaxText),
+			DescriptionLocalizations: descriptions,
+			Options:                  slashOptions(cmd),
+		})
+	}
+	for _, sub := range cmd.Subcommands {
+		definition.Options = append(definition.Options, &discordgo.ApplicationCommandOption{
+			Type:                     discordgo.ApplicationCommandOptionSubCommand,
+			Name:                     sub.Name,
+			Description:              truncateText(sub.Description, slashMaxText),
+			DescriptionLocalizations: slashDescriptions([]string{cmd.Name, sub.Name}),
+			Options:                  slashOptions(sub),
+		})
+	}
+	return definition
+}
+
+// slashDescriptions has the description of the command at path in every
+// other language whose catalogs explain it, Discord shows members the one
+// matching their client.
+func slashDescriptions(path []string) map[discordgo.Locale]string {
+	key := "help." + strings.Join(path, ".")
+	descriptions := map[discordgo.Locale]string{}
+	for _, language := range languages() {
+		if _, ok := catalogs[language][neutralPersona][key]; ok && language != fallbackLanguage {
+			text := translate(guildLocale{language, neutralPersona}, key)
+			descriptions[discordgo.Locale(language)] = truncateText(text, slashMaxText)
+		}
+	}
+	return descriptions
+}
+
+// slashOptions lists the arguments and then the flags of cmd. Discord wants
+// required options before optional ones.
+func slashOptions(cmd *Command) []*discordgo.ApplicationCommandOption {
+	var required, optional []*discordgo.ApplicationCommandOption
+	for _, arg := range cmd.Args {
+		if arg.Optional {
+			optional = append(optional, slashOption(arg, false))
+		} else {
+			required = append(required, slashOption(arg, true))
+		}
+	}
+	for _, flag := range cmd.Flags {
+		optional = append(optional, slashOption(flag, false))
+	}
+	return append(required, optional...)
+}
+
+func slashOption(arg Arg, required bool) *discordgo.ApplicationCommandOption {
+	option := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        slashName(arg.Name),
+		Description: arg.Name,
+		Required:    required,
+	}
+	if arg.Min != 0 || arg.Max != 0 {
+		option.Description = fmt.Sprintf("%s, %d to %d", arg.Name, arg.Min, arg.Max)
+		if arg.Max == 0 {
+			option.Description = fmt.Sprintf("%s, at least %d", arg.Name, arg.Min)
+		}
+	}
+
+	switch {
+	case len(arg.Choices) > 0:
+		for _, choice := range arg.Choices {
+			option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+		}
+	case arg.Complete != nil:
+		// Suggestions are searched by name, so even numeric ids are typed
+		// as text and parsed like prefix command arguments.
+		option.Autocomplete = true
+	case arg.Type == ArgInt:
+		option.Type = discordgo.ApplicationCommandOptionInteger
+		if arg.Min != 0 || arg.Max != 0 {
+			min := float64(arg.Min)
+			option.MinValue = &min
+			option.MaxValue = float64(arg.Max)
+		}
+	case arg.Type == ArgUser:
+		option.Type = discordgo.ApplicationCommandOptionUser
+	case arg.Type == ArgChannel:
+		option.Type = discordgo.ApplicationCommandOptionChannel
+	case arg.Type == ArgRole:
+		option.Type = discordgo.ApplicationCommandOptionRole
+	case arg.Type == ArgBool:
+		option.Type = discordgo.ApplicationCommandOptionBoolean
+	}
+	return option
+}
+
+// slashName makes an argument name valid as an option name, "lib id"
+// becomes "lib_id".
+func slashName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), " ", "_")
+}
+
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func interactionCreateHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		runSlashCommand(s, i.Interaction)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		completeSlashCommand(s, i.Interaction)
+	case discordgo.InteractionMessageComponent:
+		handleComponent(s, i.Interaction)
+	}
+}
+
+// handleComponent routes a button press by the part of its custom id
+// before the first colon, the rest is data for the handler.
+func handleComponent(s *discordgo.Session, i *discordgo.Interaction) {
+	parts := strings.SplitN(i.MessageComponentData().CustomID, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	switch parts[0] {
+	case suggestionButtonID:
+		runSuggestion(s, i, parts[1])
+	case pageButtonID:
+		handlePageButton(s, i, parts[1])
+	}
+}
+
+// runSlashCommand runs an invoked slash command through the same dispatch
+// as prefix commands.
+func runSlashCommand(s *discordgo.Session, i *discordgo.Interaction) {
+	data := i.ApplicationCommandData()
+	// Downloads and analysis take longer than the 3 seconds Discord waits
+	// for an answer, so say we're thinking first.
+	err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Println("Error answering interaction,", err)
+		return
+	}
+
+	replier := &interactionReplier{session: s, interaction: i}
+	ctx := interactionContext(s, i, replier)
+	ctx.Name = data.Name
+	cmd, path, options := resolveSlashCommand(data)
+	if cmd == nil {
+		ctx.Say("slash.outdated")
+		return
+	}
+	dispatch(ctx, cmd, path, func() (parsedArgs, []string, error) {
+		return slashArgs(cmd, options)
+	})
+	replier.finish()
+}
+
+// interactionContext builds the Context for a command sent as a slash
+// command.
+func interactionContext(s *discordgo.Session, i *discordgo.Interaction, r replier) *Context {
+	user := i.User
+	if i.Member != nil {
+		user = i.Member.User
+	}
+	return &Context{
+		Author:    Author{ID: user.ID, Name: user.Username},
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		Session:   s,
+		replier:   r,
+	}
+}
+
+// resolveSlashCommand finds the registry entry an interaction is for and
+// the options given to it. The command is nil when the definitions Discord
+// has don't match the registry anymore.
+func resolveSlashCommand(data discordgo.ApplicationCommandInteractionData) (*Command, []string, []*discordgo.ApplicationCommandInteractionDataOption) {
+	cmd, ok := commands[data.Name]
+	if !ok {
+		return nil, nil, nil
+	}
+	path := []string{data.Name}
+	options := data.Options
+	if len(options) == 1 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		if options[0].Name != slashDefaultSubcommand {
+			cmd = cmd.subcommand(options[0].Name)
+			if cmd == nil {
+				return nil, nil, nil
+			}
+			path = append(path, options[0].Name)
+		}
+		options = options[0].Options
+	}
+	return cmd, path, options
+}
+
+// slashArgs checks option values the way parseArgs checks words. The
+// returned tokens are the values in argument order, what ctx.Args holds for
+// prefix commands.
+func slashArgs(cmd *Command, options []*discordgo.ApplicationCommandInteractionDataOption) (parsedArgs, []string, error) {
+	given := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, option := range options {
+		given[option.Name] = option
+	}
+
+	values := parsedArgs{}
+	var tokens []string
+	for _, arg := range append(append([]Arg{}, cmd.Args...), cmd.Flags...) {
+		option, ok := given[slashName(arg.Name)]
+		if !ok {
+			if !arg.Optional && cmd.flag(arg.Name) == nil {
+				return nil, nil, &ArgError{Arg: arg.Name, Err: localErr("args.missing")}
+			}
+			continue
+		}
+		token := slashToken(option)
+		v, err := arg.parse(token)
+		if err != nil {
+			return nil, nil, &ArgError{Arg: arg.Name, Err: err}
+		}
+		values[arg.Name] = v
+		if cmd.flag(arg.Name) == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	return values, tokens, nil
+}
+
+// slashToken writes an option value the way it would be typed after a
+// prefix command. Users, channels and roles come as ids.
+func slashToken(option *discordgo.ApplicationCommandInteractionDataOption) string {
+	switch option.Type {
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(option.IntValue(), 10)
+	case discordgo.ApplicationCommandOptionBoolean:
+		return strconv.FormatBool(option.BoolValue())
+	}
+	return fmt.Sprint(option.Value)
+}
+
+func completeSlashCommand(s *discordgo.Session, i *discordgo.Interaction) {
+	cmd, _, options := resolveSlashCommand(i.ApplicationCommandData())
+	if cmd == nil {
+		return
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, option := range options {
+		if !option.Focused {
+			continue
+		}
+		for _, arg := range append(append([]Arg{}, cmd.Args...), cmd.Flags...) {
+			if slashName(arg.Name) == option.Name && arg.Complete != nil {
+				choices = arg.Complete(i.GuildID, fmt.Sprint(option.Value))
+			}
+		}
+	}
+	if len(choices) > slashMaxChoices {
+		choices = choices[:slashMaxChoices]
+	}
+
+	err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		log.Println("Error sending suggestions,", err)
+	}
+}
+
+// interactionReplier answers a slash command. The first answer replaces
+// the "thinking" message, the rest are follow-ups.
+type interactionReplier struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+
+	mu       sync.Mutex
+	answered bool
+}
+
+func (r *interactionReplier) Reply(text string) error {
+	_, err := r.send(&discordgo.WebhookParams{Content: text})
+	return err
+}
+
+func (r *interactionReplier) ReplyEmbed(embed *discordgo.MessageEmbed) error {
+	_, err := r.send(&discordgo.WebhookParams{Embeds: []*discordgo.MessageEmbed{embed}})
+	return err
+}
+
+func (r *interactionReplier) ReplyMessage(message *discordgo.MessageSend) (*discordgo.Message, error) {
+	return r.send(&discordgo.WebhookParams{
+		Content:         message.Content,
+		Embeds:          message.Embeds,
+		Components:      message.Components,
+		Files:           message.Files,
+		AllowedMentions: message.AllowedMentions,
+	})
+}
+
+func (r *interactionReplier) send(message *discordgo.WebhookParams) (*discordgo.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.answered {
+		return r.session.FollowupMessageCreate(r.interaction, true, message)
+	}
+	r.answered = true
+	edit := &discordgo.WebhookEdit{Files: message.Files, AllowedMentions: message.AllowedMentions}
+	if message.Content != "" {
+		edit.Content = &message.Content
+	}
+	if len(message.Embeds) > 0 {
+		edit.Embeds = &message.Embeds
+	}
+	if len(message.Components) > 0 {
+		edit.Components = &message.Components
+	}
+	return r.session.InteractionResponseEdit(r.interaction, edit)
+}
+
+// finish removes the "thinking" message of a command that had nothing to
+// say, like .join. Later answers still arrive as follow-ups.
+func (r *interactionReplier) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.answered {
+		return
+	}
+	r.answered = true
+	err := r.session.InteractionResponseDelete(r.interaction)
+	if err != nil {
+		log.Println("Error removing interaction response,", err)
+	}
+}
+
+// syncSlashCommands replaces the slash commands Discord knows with the
+// registry, for this guild or everywhere.
+func syncSlashCommands(ctx *Context) {
+	guild := ctx.GuildID
+	if ctx.String("scope") == "global" {
+		if !isBotOwner(ctx.Session, ctx.Author.ID) {
+			ctx.Say("slash.owner_only")
+			return
+		}
+		guild = ""
+	}
+
+	synced, err := ctx.Session.ApplicationCommandBulkOverwrite(ctx.Session.State.User.ID, guild, slashCommands())
+	if err != nil {
+		log.Println("Error syncing slash commands,", err)
+		ctx.Say("slash.sync_failed")
+		return
+	}
+	if guild == "" {
+		ctx.Say("slash.synced_global", len(synced))
+		return
+	}
+	ctx.Say("slash.synced", len(synced))
+}
+
+// completeLibraryTrack suggests library tracks by id, title or artist.
+// Typos are forgiven, the closest titles come first.
+func completeLibraryTrack(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	if quizRunning(guild) {
+		return nil
+	}
+	library, err := scanLibrary()
+	if err != nil {
+		log.Println("Error scanning library,", err)
+		return nil
+	}
+	query := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(partial), "#"))
+
+	type match struct {
+		track libraryTrack
+		name  string
+		score float64
+	}
+	var matches []match
+	for _, track := range library {
+		name := track.Title
+		if track.Artist != "" {
+			name = track.Artist + " - " + track.Title
+		}
+		score := 1.0
+		if query != "" && !strings.HasPrefix(strconv.Itoa(track.ID), query) && !strings.Contains(strings.ToLower(name), query) {
+			score = similarity(query, track.Title)
+			if byName := similarity(query, name); byName > score {
+				score = byName
+			}
+		}
+		if score >= 0.5 {
+			matches = append(matches, match{track, name, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score > matches[b].score })
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, m := range matches {
+		if len(choices) == slashMaxChoices {
+			break
+		}
+		id := strconv.Itoa(m.track.ID)
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  truncateText("#"+id+" "+m.name, slashMaxText),
+			Value: id,
+		})
+	}
+	return choices
+}
+
+// completeLibraryFolder suggests the library's folders, which work as
+// playlists for quizzes and schedules.
+func completeLibraryFolder(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	library, err := scanLibrary()
+	if err != nil {
+		log.Println("Error scanning library,", err)
+		return nil
+	}
+	seen := map[string]bool{}
+	var folders []string
+	for _, track := range library {
+		if track.Category != "" && !seen[track.Category] {
+			seen[track.Category] = true
+			folders = append(folders, track.Category)
+		}
+	}
+	sort.Strings(folders)
+	return matchingChoices(folders, partial)
+}
+
+// completeSoundClip suggests the clips at the top of the soundboard
+// folder, saved .clip recordings among them. Sound auto responses pick
+// one, .clip save can reuse a name to replace it.
+func completeSoundClip(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	files, err := ioutil.ReadDir(soundboardPath)
+	if err != nil {
+		log.Println("Error listing sound clips,", err)
+		return nil
+	}
+	var clips []string
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if !file.IsDir() && libraryExtensions[strings.ToLower(ext)] {
+			clips = append(clips, strings.TrimSuffix(file.Name(), ext))
+		}
+	}
+	return matchingChoices(clips, partial)
+}
+
+// completeScheduleTarget suggests anything .schedule add can play.
+func completeScheduleTarget(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	choices := completeLibraryFolder(guild, partial)
+	choices = append(choices, completeSoundClip(guild, partial)...)
+	return append(choices, completeLibraryTrack(guild, partial)...)
+}
+
+func matchingChoices(names []string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	query := strings.ToLower(strings.TrimSpace(partial))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), query) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  truncateText(name, slashMaxText),
+				Value: name,
+			})
+		}
+	}
+	return choices
+}
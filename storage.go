@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	dataPath     = "./data"
+	storageMutex sync.Mutex
+)
+
+// loadData reads <dataPath>/<name>.json into v. A missing file is not an
+// error and leaves v untouched.
+func loadData(name string, v interface{}) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	raw, err := ioutil.ReadFile(filepath.Join(dataPath, name+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// saveData writes v to <dataPath>/<name>.json, replacing the old file only
+// once the new one is fully written.
+func saveData(name string, v interface{}) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	raw, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dataPath, 0755)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataPath, name+".json")
+	err = ioutil.WriteFile(path+".tmp", raw, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// A guild hears about unknown commands at most this often, so ".." and
+	// "..." chatter doesn't bury the channel in replies.
+	unknownCommandCooldown = 10 * time.Second
+
+	suggestionButtonID = "suggest"
+	// Discord limits a button's custom id to 100 characters.
+	maxCustomIDLength = 100
+)
+
+var (
+	unknownCommandReplies = map[string]time.Time{}
+	unknownCommandMutex   sync.Mutex
+)
+
+// suggestCommand returns the registered name or alias closest to name, if
+// one is close enough to be a typo of it.
+func suggestCommand(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if normalizeForMatch(name) == "" {
+		return "", false
+	}
+	names := make([]string, 0, len(commands))
+	for known := range commands {
+		names = append(names, known)
+	}
+	// Ties go to the alphabetically first name, not to map order.
+	sort.Strings(names)
+
+	length := utf8.RuneCountInString(name)
+	best, bestDistance := "", length/3+2
+	for _, known := range names {
+		distance := typoDistance(name, known)
+		if distance < bestDistance && distance < length {
+			best, bestDistance = known, distance
+		}
+	}
+	return best, best != ""
+}
+
+// allowUnknownCommandReply reports whether guild may get another unknown
+// command reply yet and counts this one if so.
+func allowUnknownCommandReply(guild string, now time.Time) bool {
+	unknownCommandMutex.Lock()
+	defer unknownCommandMutex.Unlock()
+	if last, ok := unknownCommandReplies[guild]; ok && now.Sub(last) < unknownCommandCooldown {
+		return false
+	}
+	unknownCommandReplies[guild] = now
+	return true
+}
+
+// replyUnknownCommand answers a message whose command doesn't exist,
+// offering a button that runs the closest one instead.
+func replyUnknownCommand(s *discordgo.Session, m *discordgo.MessageCreate, line string) {
+	tokens, err := tokenize(line)
+	if err != nil || len(tokens) == 0 {
+		return
+	}
+	// "..." and the like are chat, not a mistyped command.
+	if normalizeForMatch(tokens[0]) == "" {
+		return
+	}
+	log.Println("{", tokens[0], "} not in command registry")
+	if !config.Suggestions || !allowUnknownCommandReply(m.GuildID, time.Now()) {
+		return
+	}
+
+	if err := waitToSend(m.ChannelID); err != nil {
+		log.Println("Error replying,", err)
+		return
+	}
+
+	prefix := prefixesFor(m.GuildID)[0]
+	suggestion, ok := suggestCommand(tokens[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, tr(m.GuildID, "suggest.unknown", prefix+tokens[0], prefix))
+		return
+	}
+
+	message := &discordgo.MessageSend{
+		Content: tr(m.GuildID, "suggest.did_you_mean", prefix+tokens[0], prefix+suggestion),
+	}
+	// The button carries the corrected line, so it can only be offered
+	// when that fits in a custom id.
+	corrected := strings.TrimSpace(suggestion + " " + strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), tokens[0])))
+	customID := suggestionButtonID + ":" + m.Author.ID + ":" + corrected
+	if len(customID) <= maxCustomIDLength {
+		message.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: tr(m.GuildID, "suggest.run", prefix+suggestion), Style: discordgo.PrimaryButton, CustomID: customID},
+			}},
+		}
+	}
+	_, err = s.ChannelMessageSendComplex(m.ChannelID, message)
+	if err != nil {
+		log.Println("Error suggesting command,", err)
+	}
+}
+
+// runSuggestion runs the corrected command line of a "did you mean"
+// button, for the author of the typo only.
+func runSuggestion(s *discordgo.Session, i *discordgo.Interaction, data string) {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	authorID, line := parts[0], parts[1]
+
+	replier := &interactionReplier{session: s, interaction: i}
+	ctx := interactionContext(s, i, replier)
+	if ctx.Author.ID != authorID {
+		err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: ctx.T("suggest.not_yours"),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.Println("Error answering interaction,", err)
+		}
+		return
+	}
+
+	err := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Println("Error answering interaction,", err)
+		return
+	}
+	// The button did its job, take it away so it isn't pressed twice.
+	if i.Message != nil {
+		_, err = editMessage(s, &discordgo.MessageEdit{
+			ID:         i.Message.ID,
+			Channel:    i.ChannelID,
+			Components: &[]discordgo.MessageComponent{},
+		})
+		if err != nil {
+			log.Println("Error removing suggestion button,", err)
+		}
+	}
+	runCommand(ctx, line)
+	replier.finish()
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	maxTagNameLength = 32
+	maxTagLength     = 2000
+	// Discord's upload limit without boosts.
+	maxTagFileSize = 25 << 20
+)
+
+var maxTagsPerGuild = 200
+
+// tag is a guild's own command answering with fixed text, an embed or
+// files. Content can use {user}, {channel} and {args}. Attachments are
+// files under the data directory, tags made before they were downloaded
+// have Discord links instead.
+type tag struct {
+	Name        string    `json:"name"`
+	Content     string    `json:"content"`
+	Embed       bool      `json:"embed"`
+	Attachments []string  `json:"attachments"`
+	Author      string    `json:"author"`
+	Created     time.Time `json:"created"`
+	Uses        int       `json:"uses"`
+}
+
+var (
+	guildTags = map[string]map[string]*tag{}
+	tagsMutex sync.Mutex
+
+	// tagInvocation is what tags run as, so they go through the same
+	// middleware as built-in commands.
+	tagInvocation = &Command{
+		Name:     "tag",
+		Usage:    ".<tag> [args]",
+		Cooldown: Cooldown{Scope: PerUser, Burst: 3, Per: 10 * time.Second},
+		Run:      invokeTag,
+	}
+)
+
+// runTag runs line as a tag of the guild. Like runCommand it returns false
+// when there is no such tag. Built-in commands are looked up first, so a
+// tag never hides one.
+func runTag(ctx *Context, line string) bool {
+	tokens, err := tokenize(line)
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+	name := strings.ToLower(tokens[0])
+	if findTag(ctx.GuildID, name) == nil {
+		return false
+	}
+	ctx.Name = name
+	return dispatch(ctx, tagInvocation, []string{name}, func() (parsedArgs, []string, error) {
+		return parsedArgs{"name": name, "args": strings.Join(tokens[1:], " ")}, tokens[1:], nil
+	})
+}
+
+// findTag returns a copy of the guild's tag called name, nil if there is
+// none.
+func findTag(guild string, name string) *tag {
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
+	t, ok := guildTags[guild][strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	found := *t
+	return &found
+}
+
+// invokeTag answers with the tag named by the "name" argument.
+func invokeTag(ctx *Context) {
+	name := strings.ToLower(ctx.String("name"))
+	tagsMutex.Lock()
+	t, ok := guildTags[ctx.GuildID][name]
+	var found tag
+	if ok {
+		t.Uses++
+		found = *t
+		err := saveData("tags", guildTags)
+		if err != nil {
+			log.Println("Error saving tags,", err)
+		}
+	}
+	tagsMutex.Unlock()
+	if !ok {
+		ctx.Say("tags.unknown_try_list", name)
+		return
+	}
+
+	text := expandTag(found.Content, ctx, ctx.String("args"))
+	// {args} is whatever the caller typed, only {user} may ping.
+	message := &discordgo.MessageSend{
+		AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{ctx.Author.ID}},
+	}
+	var links []string
+	for _, attachment := range found.Attachments {
+		if isTagLink(attachment) {
+			links = append(links, attachment)
+			continue
+		}
+		f, err := os.Open(attachment)
+		if err != nil {
+			log.Println("Error opening tag file,", err)
+			continue
+		}
+		defer f.Close()
+		message.Files = append(message.Files, &discordgo.File{Name: filepath.Base(attachment), Reader: f})
+	}
+	if !found.Embed {
+		parts := append([]string{text}, links...)
+		message.Content = strings.TrimSpace(strings.Join(parts, "\n"))
+		ctx.ReplyMessage(message)
+		return
+	}
+	image := ""
+	for _, file := range message.Files {
+		if isImageURL(file.Name) {
+			image = "attachment://" + file.Name
+			break
+		}
+	}
+	if image == "" {
+		for i, link := range links {
+			if isImageURL(link) {
+				image = link
+				links = append(links[:i], links[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(links) > 0 {
+		text = strings.TrimSpace(text + "\n" + strings.Join(links, "\n"))
+	}
+	message.Embeds = []*discordgo.MessageEmbed{Embed{}.Description(text).Image(image).Build(ctx.GuildID)}
+	ctx.ReplyMessage(message)
+}
+
+// expandTag fills in the placeholders of a tag's content.
+func expandTag(content string, ctx *Context, args string) string {
+	return strings.NewReplacer(
+		"{user}", "<@"+ctx.Author.ID+">",
+		"{channel}", "<#"+ctx.ChannelID+">",
+		"{args}", args,
+	).Replace(content)
+}
+
+func isImageURL(link string) bool {
+	// Discord's CDN links carry a query string after the file name.
+	if i := strings.IndexByte(link, '?'); i >= 0 {
+		link = link[:i]
+	}
+	switch strings.ToLower(path.Ext(link)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+func isTagLink(attachment string) bool {
+	return strings.HasPrefix(attachment, "https://") || strings.HasPrefix(attachment, "http://")
+}
+
+// downloadTagFiles keeps copies of the files attached to a command, the
+// links Discord gives out for them expire. Each call gets a directory of
+// its own under the guild's, the paths of the files in it are returned.
+func downloadTagFiles(guild string, name string, links []string) ([]string, error) {
+	if len(links) == 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(dataPath, "tags", guild, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for i, link := range links {
+		base := "file"
+		if u, err := url.Parse(link); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			base = path.Base(u.Path)
+		}
+		// Two attachments can have the same name.
+		file := filepath.Join(dir, fmt.Sprintf("%d-%s", i+1, base))
+		err = downloadFile(link, file)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		paths = append(paths, file)
+	}
+	return paths, nil
+}
+
+func downloadFile(link string, file string) error {
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", link, resp.Status)
+	}
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, maxTagFileSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxTagFileSize {
+		err = fmt.Errorf("%s is larger than %d bytes", link, maxTagFileSize)
+	}
+	return err
+}
+
+// removeTagFiles deletes the downloaded files of a tag that was changed
+// or deleted.
+func removeTagFiles(attachments []string) {
+	for _, attachment := range attachments {
+		if isTagLink(attachment) {
+			continue
+		}
+		err := os.RemoveAll(filepath.Dir(attachment))
+		if err != nil {
+			log.Println("Error removing tag files,", err)
+		}
+	}
+}
+
+// checkTagName explains what is wrong with name as a new tag's name.
+func checkTagName(name string) error {
+	if utf8.RuneCountInString(name) > maxTagNameLength {
+		return localErr("tags.name_too_long", maxTagNameLength)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return localErr("tags.name_chars")
+		}
+	}
+	if _, ok := commands[name]; ok {
+		return localErr("tags.name_taken", name)
+	}
+	return nil
+}
+
+func createTag(ctx *Context) {
+	name := strings.ToLower(ctx.String("name"))
+	if err := checkTagName(name); err != nil {
+		ctx.Say("error", ctx.Explain(err))
+		return
+	}
+	t := &tag{
+		Name:        name,
+		Content:     ctx.String("text"),
+		Embed:       ctx.Bool("embed"),
+		Attachments: ctx.Attachments,
+		Author:      ctx.Author.ID,
+		Created:     time.Now(),
+	}
+	if !checkTagContent(ctx, t) {
+		return
+	}
+	files, err := downloadTagFiles(ctx.GuildID, name, ctx.Attachments)
+	if err != nil {
+		log.Println("Error downloading tag files,", err)
+		ctx.Say("tags.download_failed")
+		return
+	}
+	t.Attachments = files
+
+	tagsMutex.Lock()
+	tags, ok := guildTags[ctx.GuildID]
+	if !ok {
+		tags = map[string]*tag{}
+		guildTags[ctx.GuildID] = tags
+	}
+	_, exists := tags[name]
+	full := len(tags) >= maxTagsPerGuild
+	if !exists && !full {
+		tags[name] = t
+		err = saveData("tags", guildTags)
+	}
+	tagsMutex.Unlock()
+	if exists || full {
+		removeTagFiles(files)
+	}
+
+	switch {
+	case exists:
+		ctx.Say("tags.exists", name)
+	case full:
+		ctx.Say("tags.full", maxTagsPerGuild)
+	case err != nil:
+		log.Println("Error saving tags,", err)
+		ctx.Say("tags.save_failed")
+	default:
+		ctx.Say("tags.created", name)
+	}
+}
+
+// checkTagContent makes sure a tag answers with something and fits in
+// one message.
+func checkTagContent(ctx *Context, t *tag) bool {
+	if strings.TrimSpace(t.Content) == "" && len(t.Attachments) == 0 {
+		ctx.Say("tags.empty")
+		return false
+	}
+	if utf8.RuneCountInString(t.Content) > maxTagLength {
+		ctx.Say("tags.too_long", maxTagLength)
+		return false
+	}
+	return true
+}
+
+func editTag(ctx *Context) {
+	name := strings.ToLower(ctx.String("name"))
+	edited := findTag(ctx.GuildID, name)
+	if edited == nil {
+		ctx.Say("tags.unknown", name)
+		return
+	}
+	edited.Content = ctx.String("text")
+	if ctx.Has("embed") {
+		edited.Embed = ctx.Bool("embed")
+	}
+	// Without new files the old ones stay.
+	old := edited.Attachments
+	if len(ctx.Attachments) > 0 {
+		edited.Attachments = ctx.Attachments
+	}
+	if !checkTagContent(ctx, edited) {
+		return
+	}
+	if len(ctx.Attachments) > 0 {
+		files, err := downloadTagFiles(ctx.GuildID, name, ctx.Attachments)
+		if err != nil {
+			log.Println("Error downloading tag files,", err)
+			ctx.Say("tags.download_failed")
+			return
+		}
+		edited.Attachments = files
+	}
+
+	tagsMutex.Lock()
+	var err error
+	current, ok := guildTags[ctx.GuildID][name]
+	if ok {
+		// Uses may have gone up since the copy was taken.
+		edited.Uses = current.Uses
+		guildTags[ctx.GuildID][name] = edited
+		err = saveData("tags", guildTags)
+	}
+	tagsMutex.Unlock()
+	switch {
+	case len(ctx.Attachments) == 0:
+	case !ok:
+		// Deleted in the meantime, the new files have no tag.
+		removeTagFiles(edited.Attachments)
+	case err == nil:
+		removeTagFiles(old)
+	}
+	if err != nil {
+		log.Println("Error saving tags,", err)
+		ctx.Say("tags.save_failed")
+		return
+	}
+	ctx.Say("tags.updated")
+}
+
+func deleteTag(ctx *Context) {
+	name := strings.ToLower(ctx.String("name"))
+	tagsMutex.Lock()
+	deleted, ok := guildTags[ctx.GuildID][name]
+	var err error
+	if ok {
+		delete(guildTags[ctx.GuildID], name)
+		err = saveData("tags", guildTags)
+	}
+	tagsMutex.Unlock()
+	if ok && err == nil {
+		removeTagFiles(deleted.Attachments)
+	}
+
+	switch {
+	case !ok:
+		ctx.Say("tags.unknown", name)
+	case err != nil:
+		log.Println("Error saving tags,", err)
+		ctx.Say("tags.delete_failed")
+	default:
+		ctx.Say("tags.deleted")
+	}
+}
+
+// guildTagList returns copies of the guild's tags, most used first.
+func guildTagList(guild string) []tag {
+	tagsMutex.Lock()
+	var tags []tag
+	for _, t := range guildTags[guild] {
+		tags = append(tags, *t)
+	}
+	tagsMutex.Unlock()
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Uses != tags[j].Uses {
+			return tags[i].Uses > tags[j].Uses
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags
+}
+
+func listTags(ctx *Context) {
+	tags := guildTagList(ctx.GuildID)
+	if len(tags) == 0 {
+		ctx.Say("tags.none")
+		return
+	}
+	var lines []string
+	for _, t := range tags {
+		lines = append(lines, ctx.T("tags.line", t.Uses, t.Name))
+	}
+	paginateLines(ctx, ctx.T("tags.title"), lines)
+}
+
+func showTagInfo(ctx *Context) {
+	t := findTag(ctx.GuildID, ctx.String("name"))
+	if t == nil {
+		ctx.Say("tags.unknown", ctx.String("name"))
+		return
+	}
+	kind := ctx.T("tags.kind_text")
+	if t.Embed {
+		kind = ctx.T("tags.kind_embed")
+	}
+	ctx.Say("tags.info", t.Uses, t.Name, kind, t.Author, t.Created.Format("2006-01-02"),
+		ctx.T("tags.attachments", len(t.Attachments)))
+}
+
+// completeTag suggests the guild's tag names.
+func completeTag(guild string, partial string) []*discordgo.ApplicationCommandOptionChoice {
+	var names []string
+	for _, t := range guildTagList(guild) {
+		names = append(names, t.Name)
+	}
+	return matchingChoices(names, partial)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInvokeTagMentions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmasik-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath, oldTags := dataPath, guildTags
+	defer func() { dataPath, guildTags = oldPath, oldTags }()
+	dataPath = dir
+	guildTags = map[string]map[string]*tag{"g1": {"hi": {Name: "hi", Content: "{user} says {args}"}}}
+
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "member")
+	dispatch(ctx, tagInvocation, []string{"hi"}, func() (parsedArgs, []string, error) {
+		return parsedArgs{"name": "hi", "args": "@everyone <@&role-dj>"}, nil, nil
+	})
+
+	if len(replier.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(replier.messages))
+	}
+	message := replier.messages[0]
+	if want := "<@member> says @everyone <@&role-dj>"; message.Content != want {
+		t.Errorf("content = %q, want %q", message.Content, want)
+	}
+	allowed := message.AllowedMentions
+	if allowed == nil || len(allowed.Parse) != 0 || len(allowed.Roles) != 0 || len(allowed.Users) != 1 || allowed.Users[0] != "member" {
+		t.Errorf("allowed mentions = %+v, want only the author", allowed)
+	}
+}
+
+func TestTagAttachmentsDownloaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmasik-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath, oldTags := dataPath, guildTags
+	defer func() { dataPath, guildTags = oldPath, oldTags }()
+	dataPath = dir
+	guildTags = map[string]map[string]*tag{}
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("picture"))
+	}))
+	defer cdn.Close()
+
+	s := newFakeSession(t)
+	ctx, replier := newTestContext(s, "mod")
+	ctx.Attachments = []string{cdn.URL + "/attachments/1/2/logo.png?ex=abc&is=def"}
+	ctx.values = parsedArgs{"name": "logo", "embed": true}
+	createTag(ctx)
+
+	created := findTag("g1", "logo")
+	if created == nil || len(created.Attachments) != 1 {
+		t.Fatalf("tag = %+v, replies %q, want one attachment", created, replier.replies)
+	}
+	file := created.Attachments[0]
+	if raw, err := ioutil.ReadFile(file); err != nil || string(raw) != "picture" || !strings.HasPrefix(file, dir) {
+		t.Errorf("attachment %s = %q, %v, want the download under the data directory", file, raw, err)
+	}
+
+	ctx, replier = newTestContext(s, "member")
+	ctx.values = parsedArgs{"name": "logo"}
+	invokeTag(ctx)
+	if len(replier.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(replier.messages))
+	}
+	message := replier.messages[0]
+	if len(message.Files) != 1 || message.Files[0].Name != "1-logo.png" ||
+		len(message.Embeds) != 1 || message.Embeds[0].Image == nil || message.Embeds[0].Image.URL != "attachment://1-logo.png" {
+		t.Errorf("message = %+v, want the file uploaded and shown in the embed", message)
+	}
+
+	ctx, _ = newTestContext(s, "mod")
+	ctx.values = parsedArgs{"name": "logo"}
+	deleteTag(ctx)
+	if _, err := os.Stat(filepath.Dir(file)); !os.IsNotExist(err) {
+		t.Errorf("files of the deleted tag are still there: %v", err)
+	}
+}
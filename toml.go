@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the part of TOML config files need: comments, [table]
+// headers, dotted keys, strings, numbers, booleans and one line arrays of
+// them. Values come back under their full dotted key, arrays as []string
+// and the rest as text.
+func parseTOML(text string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	table := ""
+	for i, line := range strings.Split(text, "\n") {
+		if hash := indexOutsideQuotes(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: arrays of tables aren't supported", i+1)
+			}
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: table header is missing its ]", i+1)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if !isTOMLKey(name) {
+				return nil, fmt.Errorf("line %d: bad table name %q", i+1, name)
+			}
+			table = name
+			continue
+		}
+
+		eq := indexOutsideQuotes(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !isTOMLKey(key) {
+			return nil, fmt.Errorf("line %d: bad key %q", i+1, key)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is set twice", i+1, key)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// isTOMLKey reports whether key is made of bare keys joined by dots.
+func isTOMLKey(key string) bool {
+	for _, part := range strings.Split(key, ".") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parseTOMLValue(text string) (interface{}, error) {
+	if !strings.HasPrefix(text, "[") {
+		return parseTOMLScalar(text)
+	}
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("array is missing its ], arrays have to fit on one line")
+	}
+	list := []string{}
+	rest := strings.TrimSpace(text[1 : len(text)-1])
+	for rest != "" {
+		item := rest
+		rest = ""
+		if comma := indexOutsideQuotes(item, ','); comma >= 0 {
+			item, rest = item[:comma], strings.TrimSpace(item[comma+1:])
+		}
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "[") {
+			return nil, fmt.Errorf("nested arrays aren't supported")
+		}
+		value, err := parseTOMLScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+func parseTOMLScalar(text string) (string, error) {
+	switch {
+	case text == "":
+		return "", fmt.Errorf("value is missing")
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("can't read string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") || strings.Contains(text[1:len(text)-1], "'") {
+			return "", fmt.Errorf("can't read string %s", text)
+		}
+		return text[1 : len(text)-1], nil
+	case text == "true" || text == "false":
+		return text, nil
+	}
+	number := strings.Replace(text, "_", "", -1)
+	if _, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return number, nil
+	}
+	if _, err := strconv.ParseFloat(number, 64); err == nil {
+		return number, nil
+	}
+	return "", fmt.Errorf("can't read value %s, text needs quotes", text)
+}
+
+// indexOutsideQuotes is strings.IndexByte that skips over quoted strings.
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
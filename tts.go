@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ttsEngine renders text into a wav file using some locally installed
+// speech synthesizer.
+type ttsEngine interface {
+	Synthesize(text string, lang string, voice string, out string) error
+}
+
+type espeakEngine struct {
+	Binary string
+}
+
+func (e espeakEngine) Synthesize(text string, lang string, voice string, out string) error {
+	if voice != "" {
+		lang += "+" + voice
+	}
+	cmd := exec.Command(e.Binary, "-v", lang, "-w", out, "--stdin")
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// piperEngine picks a model from ModelDir by voice, falling back to the
+// language name, e.g. ./voices/ru.onnx.
+type piperEngine struct {
+	Binary   string
+	ModelDir string
+}
+
+func (e piperEngine) Synthesize(text string, lang string, voice string, out string) error {
+	model := voice
+	if model == "" {
+		model = lang
+	}
+	cmd := exec.Command(e.Binary, "--model", filepath.Join(e.ModelDir, model+".onnx"), "--output_file", out)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+type ttsSettings struct {
+	Language    string `json:"language"`
+	Voice       string `json:"voice"`
+	Limit       int    `json:"limit"`
+	ReadChannel string `json:"read_channel"`
+}
+
+const ttsMaxLimit = 1000
+
+var (
+	ttsDefaultLimit = 200
+
+	tts ttsEngine = espeakEngine{Binary: "espeak-ng"}
+
+	ttsGuilds = map[string]*ttsSettings{}
+	ttsMutex  sync.Mutex
+
+	errNotConnected = localErr("tts.not_connected")
+)
+
+func guildTTSSettings(guildID string) ttsSettings {
+	ttsMutex.Lock()
+	defer ttsMutex.Unlock()
+
+	if settings, ok := ttsGuilds[guildID]; ok {
+		return *settings
+	}
+	return ttsSettings{Language: "en", Limit: ttsDefaultLimit}
+}
+
+func updateTTSSettings(guildID string, update func(*ttsSettings)) error {
+	ttsMutex.Lock()
+	defer ttsMutex.Unlock()
+
+	settings, ok := ttsGuilds[guildID]
+	if !ok {
+		settings = &ttsSettings{Language: "en", Limit: ttsDefaultLimit}
+		ttsGuilds[guildID] = settings
+	}
+	update(settings)
+	return saveData("tts", ttsGuilds)
+}
+
+// speak synthesizes text and mixes it over whatever the guild's voice
+// connection is playing. An empty lang uses the guild default.
+func speak(guildID string, text string, lang string) error {
+	if !config.TTS {
+		return localErr("tts.disabled")
+	}
+	voice, _ := findVoiceConnection(guildID, "")
+	if voice.Mixer == nil {
+		return errNotConnected
+	}
+	settings := guildTTSSettings(guildID)
+	if lang == "" {
+		lang = settings.Language
+	}
+	if len([]rune(text)) > settings.Limit {
+		return localErr("tts.too_long", settings.Limit)
+	}
+
+	tmp, err := ioutil.TempFile("", "dmasik-tts-*.wav")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	err = tts.Synthesize(text, lang, settings.Voice, tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	src, err := newFFmpegSource(tmp.Name(), ffmpegOptions{})
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	src.cleanup = func() { os.Remove(tmp.Name()) }
+	voice.Mixer.Overlay(src)
+	return nil
+}
+
+func sayText(ctx *Context) {
+	err := speak(ctx.GuildID, ctx.String("text"), "")
+	if err != nil {
+		ctx.Say("tts.failed", ctx.Explain(err))
+	}
+}
+
+func sayTextInLanguage(ctx *Context) {
+	err := speak(ctx.GuildID, ctx.String("text"), ctx.String("lang"))
+	if err != nil {
+		ctx.Say("tts.failed", ctx.Explain(err))
+	}
+}
+
+func showTTSSettings(ctx *Context) {
+	settings := guildTTSSettings(ctx.GuildID)
+	readChannel := ctx.T("tts.read_off")
+	if settings.ReadChannel != "" {
+		readChannel = "<#" + settings.ReadChannel + ">"
+	}
+	ctx.Say("tts.settings", settings.Language, settings.Voice, settings.Limit, readChannel)
+}
+
+func setTTSLanguage(ctx *Context) {
+	configureTTS(ctx, func(settings *ttsSettings) { settings.Language = ctx.String("language") })
+}
+
+func setTTSVoice(ctx *Context) {
+	configureTTS(ctx, func(settings *ttsSettings) { settings.Voice = ctx.String("voice") })
+}
+
+func setTTSLimit(ctx *Context) {
+	configureTTS(ctx, func(settings *ttsSettings) { settings.Limit = ctx.Int("limit") })
+}
+
+func setTTSReadChannel(ctx *Context) {
+	channel := ctx.ChannelID
+	if ctx.Has("off") {
+		channel = ""
+	}
+	configureTTS(ctx, func(settings *ttsSettings) { settings.ReadChannel = channel })
+}
+
+func configureTTS(ctx *Context, update func(*ttsSettings)) {
+	err := updateTTSSettings(ctx.GuildID, update)
+	if err != nil {
+		log.Println(err)
+		ctx.Say("tts.save_failed")
+		return
+	}
+	ctx.Say("tts.updated")
+}
+
+// readForMutedMember speaks messages from the guild's TTS read channel when
+// the author sits muted in the bot's voice channel.
+func readForMutedMember(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !config.TTS {
+		return
+	}
+	settings := guildTTSSettings(m.GuildID)
+	if settings.ReadChannel == "" || settings.ReadChannel != m.ChannelID || m.Content == "" {
+		return
+	}
+	voice, _ := findVoiceConnection(m.GuildID, "")
+	if voice.Mixer == nil {
+		return
+	}
+	guild, err := s.State.Guild(m.GuildID)
+	if err != nil {
+		return
+	}
+
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID == m.Author.ID && vs.ChannelID == voice.Channel && (vs.SelfMute || vs.Mute) {
+			text := []rune(m.Author.Username + ": " + m.Content)
+			if len(text) > settings.Limit {
+				text = text[:settings.Limit]
+			}
+			err = speak(m.GuildID, string(text), "")
+			if err != nil {
+				log.Println("Error reading message for muted member,", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/cmplx"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	visualSampleRate = 16000
+	visualMaxLength  = 10 * time.Minute
+	visualWidth      = 1000
+	visualHeader     = 24
+
+	spectrogramFFTSize  = 512
+	spectrogramMelBands = 128
+)
+
+var (
+	visualBackground = color.RGBA{0x23, 0x27, 0x2a, 0xff}
+	visualForeground = color.RGBA{0x00, 0xff, 0x00, 0xff}
+	visualText       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+	// Gradient stops for the spectrogram, quiet to loud.
+	spectrogramPalette = []color.RGBA{
+		{0x00, 0x00, 0x04, 0xff},
+		{0x3b, 0x0f, 0x70, 0xff},
+		{0x8c, 0x29, 0x81, 0xff},
+		{0xde, 0x49, 0x68, 0xff},
+		{0xfe, 0x9f, 0x6d, 0xff},
+		{0xfc, 0xfd, 0xbf, 0xff},
+	}
+)
+
+// decodeMono decodes up to visualMaxLength of file into mono float samples
+// at visualSampleRate.
+func decodeMono(file string) ([]float32, error) {
+	run := exec.Command("ffmpeg", "-t", strconv.Itoa(int(visualMaxLength.Seconds())), "-i", file, "-f", "f32le", "-ac", "1", "-ar", strconv.Itoa(visualSampleRate), "pipe:1")
+	out, err := run.Output()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]float32, len(out)/4)
+	err = binary.Read(bytes.NewReader(out), binary.LittleEndian, &samples)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no audio in " + file)
+	}
+	return samples, nil
+}
+
+func samplesDuration(samples []float32) time.Duration {
+	return time.Duration(len(samples)) * time.Second / time.Duration(visualSampleRate)
+}
+
+func formatTrackLength(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func drawHeader(img draw.Image, title string, duration time.Duration) {
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(visualText),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(6, 16),
+	}
+	drawer.DrawString(title + "  [" + formatTrackLength(duration) + "]")
+}
+
+func renderWaveform(samples []float32, title string) image.Image {
+	height := 200
+	img := image.NewRGBA(image.Rect(0, 0, visualWidth, height+visualHeader))
+	draw.Draw(img, img.Bounds(), image.NewUniform(visualBackground), image.Point{}, draw.Src)
+	drawHeader(img, title, samplesDuration(samples))
+
+	mid := visualHeader + height/2
+	perColumn := float64(len(samples)) / float64(visualWidth)
+	for x := 0; x < visualWidth; x++ {
+		from, to := int(float64(x)*perColumn), int(float64(x+1)*perColumn)
+		if to <= from {
+			to = from + 1
+		}
+		if to > len(samples) {
+			break
+		}
+		lo, hi := float32(0), float32(0)
+		for _, sample := range samples[from:to] {
+			if sample < lo {
+				lo = sample
+			}
+			if sample > hi {
+				hi = sample
+			}
+		}
+		top := mid - int(math.Min(float64(hi), 1)*float64(height/2))
+		bottom := mid - int(math.Max(float64(lo), -1)*float64(height/2))
+		for y := top; y <= bottom; y++ {
+			img.Set(x, y, visualForeground)
+		}
+	}
+	return img
+}
+
+func renderSpectrogram(samples []float32, title string) image.Image {
+	height := spectrogramMelBands * 2
+	img := image.NewRGBA(image.Rect(0, 0, visualWidth, height+visualHeader))
+	draw.Draw(img, img.Bounds(), image.NewUniform(visualBackground), image.Point{}, draw.Src)
+	drawHeader(img, title, samplesDuration(samples))
+
+	filters := melFilterbank(spectrogramMelBands, spectrogramFFTSize, visualSampleRate)
+	window := make([]float64, spectrogramFFTSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(spectrogramFFTSize-1))
+	}
+
+	columns := make([][]float64, visualWidth)
+	peak := -math.MaxFloat64
+	hop := float64(len(samples)-spectrogramFFTSize) / float64(visualWidth)
+	buf := make([]complex128, spectrogramFFTSize)
+	for x := range columns {
+		start := int(float64(x) * hop)
+		for i := range buf {
+			sample := 0.0
+			if start >= 0 && start+i < len(samples) {
+				sample = float64(samples[start+i])
+			}
+			buf[i] = complex(sample*window[i], 0)
+		}
+		fft(buf)
+
+		column := make([]float64, spectrogramMelBands)
+		for band, filter := range filters {
+			energy := 1e-10
+			for bin, weight := range filter {
+				if weight != 0 {
+					magnitude := cmplx.Abs(buf[bin])
+					energy += weight * magnitude * magnitude
+				}
+			}
+			column[band] = 10 * math.Log10(energy)
+			if column[band] > peak {
+				peak = column[band]
+			}
+		}
+		columns[x] = column
+	}
+
+	const dynamicRange = 80.0
+	for x, column := range columns {
+		for band, db := range column {
+			level := (db - (peak - dynamicRange)) / dynamicRange
+			c := paletteColor(level)
+			y := visualHeader + height - 1 - band*2
+			img.Set(x, y, c)
+			img.Set(x, y-1, c)
+		}
+	}
+	return img
+}
+
+func paletteColor(level float64) color.RGBA {
+	if level <= 0 {
+		return spectrogramPalette[0]
+	}
+	if level >= 1 {
+		return spectrogramPalette[len(spectrogramPalette)-1]
+	}
+	pos := level * float64(len(spectrogramPalette)-1)
+	i := int(pos)
+	t := pos - float64(i)
+	a, b := spectrogramPalette[i], spectrogramPalette[i+1]
+	mixChannel := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{mixChannel(a.R, b.R), mixChannel(a.G, b.G), mixChannel(a.B, b.B), 0xff}
+}
+
+// melFilterbank returns triangular filters over the first fftSize/2+1 bins,
+// spaced evenly on the mel scale up to the Nyquist frequency.
+func melFilterbank(bands int, fftSize int, sampleRate int) [][]float64 {
+	hzToMel := func(hz float64) float64 { return 2595 * math.Log10(1+hz/700) }
+	melToHz := func(mel float64) float64 { return 700 * (math.Pow(10, mel/2595) - 1) }
+
+	bins := fftSize/2 + 1
+	maxMel := hzToMel(float64(sampleRate) / 2)
+	points := make([]float64, bands+2)
+	for i := range points {
+		hz := melToHz(maxMel * float64(i) / float64(bands+1))
+		points[i] = hz * float64(fftSize) / float64(sampleRate)
+	}
+
+	filters := make([][]float64, bands)
+	for band := range filters {
+		left, center, right := points[band], points[band+1], points[band+2]
+		filter := make([]float64, bins)
+		for bin := range filter {
+			f := float64(bin)
+			switch {
+			case f > left && f <= center:
+				filter[bin] = (f - left) / (center - left)
+			case f > center && f < right:
+				filter[bin] = (right - f) / (right - center)
+			}
+		}
+		filters[band] = filter
+	}
+	return filters
+}
+
+// fft is an in-place iterative radix-2 FFT, len(a) must be a power of two.
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u, v := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+}
+
+// renderTrackImage returns a PNG of kind "waveform" or "spectrogram" for
+// file, cached under dataPath until the file changes.
+func renderTrackImage(file string, kind string) ([]byte, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprint(file, info.Size(), info.ModTime().UnixNano(), kind))))
+	cachePath := filepath.Join(dataPath, "images", key+".png")
+	if cached, err := ioutil.ReadFile(cachePath); err == nil {
+		return cached, nil
+	}
+
+	samples, err := decodeMono(file)
+	if err != nil {
+		return nil, err
+	}
+	title := filepath.Base(file)
+	var img image.Image
+	if kind == "spectrogram" {
+		img = renderSpectrogram(samples, title)
+	} else {
+		img = renderWaveform(samples, title)
+	}
+
+	var out bytes.Buffer
+	err = png.Encode(&out, img)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+		ioutil.WriteFile(cachePath, out.Bytes(), 0644)
+	}
+	return out.Bytes(), nil
+}
+
+// withWaveform returns embed with the waveform of file as its thumbnail
+// and the file to send along. Without a waveform there is no thumbnail.
+func withWaveform(embed Embed, file string) (Embed, []*discordgo.File) {
+	waveform, err := renderTrackImage(file, "waveform")
+	if err != nil {
+		log.Println("Error rendering waveform,", err)
+		return embed.Thumbnail(""), nil
+	}
+	return embed.Thumbnail("attachment://waveform.png"),
+		[]*discordgo.File{{Name: "waveform.png", ContentType: "image/png", Reader: bytes.NewReader(waveform)}}
+}
+
+func showWaveform(ctx *Context) {
+	sendTrackImage(ctx, "waveform")
+}
+
+func showSpectrogram(ctx *Context) {
+	sendTrackImage(ctx, "spectrogram")
+}
+
+func sendTrackImage(ctx *Context, kind string) {
+	var file string
+	if ctx.Has("lib id") {
+		id := ctx.Int("lib id")
+		library, _ := scanLibrary()
+		if id > len(library) {
+			ctx.UsageError(&ArgError{Arg: "lib id", Err: localErr("args.between", "1", strconv.Itoa(len(library)))})
+			return
+		}
+		file = library[id-1].Path
+	} else {
+		song, ok := currentSong(ctx.GuildID)
+		if !ok || song.Type != "file" {
+			ctx.Say("library.nothing_playing." + kind)
+			return
+		}
+		file = song.Link
+	}
+
+	ctx.Session.ChannelTyping(ctx.ChannelID)
+	picture, err := renderTrackImage(file, kind)
+	if err != nil {
+		log.Println("Error rendering", kind, err)
+		ctx.Say("track.decode_failed")
+		return
+	}
+	ctx.ReplyMessage(&discordgo.MessageSend{
+		Files: []*discordgo.File{{Name: kind + ".png", ContentType: "image/png", Reader: bytes.NewReader(picture)}},
+	})
+}
+
+func showNowPlaying(ctx *Context) {
+	song, ok := currentSong(ctx.GuildID)
+	if !ok {
+		ctx.Say("nowplaying.nothing")
+		return
+	}
+	embed := newEmbed(ctx.T("nowplaying.title")).Description(songLabel(song))
+	if song.Type != "file" {
+		ctx.ReplyEmbed(embed)
+		return
+	}
+	if duration, err := probeDuration(song.Link); err == nil {
+		embed = embed.InlineField(ctx.T("nowplaying.length"), formatTrackLength(duration))
+	}
+	if loudness, ok := trackLoudnessInfo(song.Link); ok {
+		embed = embed.InlineField(ctx.T("nowplaying.loudness"),
+			ctx.T("nowplaying.loudness_value", loudness.Integrated, loudness.Peak, normalizationGain(song.Link)))
+	}
+	embed, files := withWaveform(embed, song.Link)
+	ctx.ReplyMessage(&discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed.Build(ctx.GuildID)}, Files: files})
+}
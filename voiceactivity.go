@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// voiceSessionRetention is how long finished sessions are kept around
+	// for weekly stats, all-time totals live in voiceActivity.Totals.
+	voiceSessionRetention = 30 * 24 * time.Hour
+	// Voice activity is saved this often when it changed, not on every
+	// voice state update.
+	voiceFlushInterval = time.Minute
+)
+
+type voiceMember struct {
+	ChannelID  string        `json:"channel_id"`
+	JoinedAt   time.Time     `json:"joined_at"`
+	Muted      bool          `json:"muted"`
+	MutedSince time.Time     `json:"muted_since"`
+	MutedFor   time.Duration `json:"muted_for"`
+}
+
+type voiceSession struct {
+	UserID    string        `json:"user_id"`
+	ChannelID string        `json:"channel_id"`
+	Start     time.Time     `json:"start"`
+	End       time.Time     `json:"end"`
+	MutedFor  time.Duration `json:"muted_for"`
+}
+
+type voiceActivity struct {
+	Members  map[string]*voiceMember  `json:"members"`
+	Sessions []voiceSession           `json:"sessions"`
+	Totals   map[string]time.Duration `json:"totals"`
+	// SavedAt is the last time Members was known to be right, open
+	// sessions found after a restart end there.
+	SavedAt time.Time `json:"saved_at"`
+}
+
+var (
+	voiceGuilds = map[string]*voiceActivity{}
+	voiceMutex  sync.Mutex
+	// voiceDirty is set when voiceGuilds changed since the last save.
+	voiceDirty bool
+	// voiceReconciled has the guilds whose stored members were checked
+	// against Discord since the bot started.
+	voiceReconciled = map[string]bool{}
+)
+
+func guildVoiceActivity(guildID string) *voiceActivity {
+	activity, ok := voiceGuilds[guildID]
+	if !ok {
+		activity = &voiceActivity{}
+		voiceGuilds[guildID] = activity
+	}
+	if activity.Members == nil {
+		activity.Members = map[string]*voiceMember{}
+	}
+	if activity.Totals == nil {
+		activity.Totals = map[string]time.Duration{}
+	}
+	return activity
+}
+
+func voiceStateMuted(vs *discordgo.VoiceState) bool {
+	return vs.SelfMute || vs.SelfDeaf || vs.Mute || vs.Deaf
+}
+
+// leave closes the member's current session at now.
+func (a *voiceActivity) leave(userID string, now time.Time) {
+	member, ok := a.Members[userID]
+	if !ok {
+		return
+	}
+	if now.Before(member.JoinedAt) {
+		now = member.JoinedAt
+	}
+	if member.Muted && now.Before(member.MutedSince) {
+		now = member.MutedSince
+	}
+	if member.Muted {
+		member.MutedFor += now.Sub(member.MutedSince)
+	}
+	a.Sessions = append(a.Sessions, voiceSession{
+		UserID:    userID,
+		ChannelID: member.ChannelID,
+		Start:     member.JoinedAt,
+		End:       now,
+		MutedFor:  member.MutedFor,
+	})
+	a.Totals[userID] += now.Sub(member.JoinedAt)
+	delete(a.Members, userID)
+}
+
+func (a *voiceActivity) join(vs *discordgo.VoiceState, now time.Time) {
+	a.Members[vs.UserID] = &voiceMember{
+		ChannelID:  vs.ChannelID,
+		JoinedAt:   now,
+		Muted:      voiceStateMuted(vs),
+		MutedSince: now,
+	}
+}
+
+func (a *voiceActivity) update(vs *discordgo.VoiceState, now time.Time) {
+	member, ok := a.Members[vs.UserID]
+	switch {
+	case vs.ChannelID == "":
+		a.leave(vs.UserID, now)
+	case !ok:
+		a.join(vs, now)
+	case member.ChannelID != vs.ChannelID:
+		a.leave(vs.UserID, now)
+		a.join(vs, now)
+	case member.Muted != voiceStateMuted(vs):
+		if member.Muted {
+			member.MutedFor += now.Sub(member.MutedSince)
+		} else {
+			member.MutedSince = now
+		}
+		member.Muted = !member.Muted
+	}
+}
+
+func (a *voiceActivity) prune(now time.Time) {
+	kept := a.Sessions[:0]
+	for _, session := range a.Sessions {
+		if now.Sub(session.End) < voiceSessionRetention {
+			kept = append(kept, session)
+		}
+	}
+	a.Sessions = kept
+}
+
+// timeSince sums how long userID spent in voice after since, counting the
+// session that is still open.
+func (a *voiceActivity) timeSince(userID string, since time.Time, now time.Time) time.Duration {
+	var total time.Duration
+	overlap := func(start, end time.Time) {
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	for _, session := range a.Sessions {
+		if session.UserID == userID {
+			overlap(session.Start, session.End)
+		}
+	}
+	if member, ok := a.Members[userID]; ok {
+		overlap(member.JoinedAt, now)
+	}
+	return total
+}
+
+func voiceStateUpdateHandler(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.UserID == s.State.User.ID || v.GuildID == "" {
+		return
+	}
+	now := time.Now()
+
+	voiceMutex.Lock()
+	defer voiceMutex.Unlock()
+	activity := guildVoiceActivity(v.GuildID)
+	activity.update(v.VoiceState, now)
+	activity.prune(now)
+	voiceDirty = true
+}
+
+// guildCreateVoiceHandler reconciles stored members with the voice states
+// Discord reports on (re)connect, so sessions don't stay open across
+// restarts.
+func guildCreateVoiceHandler(s *discordgo.Session, g *discordgo.GuildCreate) {
+	present := map[string]*discordgo.VoiceState{}
+	for _, vs := range g.VoiceStates {
+		if vs.UserID != s.State.User.ID {
+			vs.GuildID = g.ID
+			present[vs.UserID] = vs
+		}
+	}
+
+	voiceMutex.Lock()
+	defer voiceMutex.Unlock()
+	guildVoiceActivity(g.ID).reconcile(present, time.Now(), !voiceReconciled[g.ID])
+	voiceReconciled[g.ID] = true
+	voiceDirty = true
+}
+
+// reconcile makes Members match the voice states Discord reports. After a
+// restart nobody's session is trusted to have lasted through the downtime:
+// every stored one ends at SavedAt and present members start over at now.
+func (a *voiceActivity) reconcile(present map[string]*discordgo.VoiceState, now time.Time, restarted bool) {
+	for userID := range a.Members {
+		switch _, ok := present[userID]; {
+		case restarted:
+			a.leave(userID, a.SavedAt)
+		case !ok:
+			a.leave(userID, now)
+		}
+	}
+	for _, vs := range present {
+		a.update(vs, now)
+	}
+}
+
+// startVoiceFlusher saves voice activity every voiceFlushInterval when it
+// changed.
+func startVoiceFlusher() {
+	go func() {
+		for range time.Tick(voiceFlushInterval) {
+			flushVoiceActivity()
+		}
+	}()
+}
+
+func flushVoiceActivity() {
+	voiceMutex.Lock()
+	defer voiceMutex.Unlock()
+	// Open sessions need SavedAt kept fresh even when nothing changed.
+	now := time.Now()
+	for _, activity := range voiceGuilds {
+		activity.SavedAt = now
+		if len(activity.Members) > 0 {
+			voiceDirty = true
+		}
+	}
+	if !voiceDirty {
+		return
+	}
+	err := saveData("voice", voiceGuilds)
+	if err != nil {
+		log.Println("Error saving voice activity,", err)
+		return
+	}
+	voiceDirty = false
+}
+
+func startOfWeek(now time.Time) time.Time {
+	now = now.UTC()
+	day := (int(now.Weekday()) + 6) % 7
+	return time.Date(now.Year(), now.Month(), now.Day()-day, 0, 0, 0, 0, time.UTC)
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+func memberName(s *discordgo.Session, guildID string, userID string) string {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil || member.User == nil {
+		return "<@" + userID + ">"
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
+
+func showVoiceTime(ctx *Context) {
+	userID := ctx.Author.ID
+	if ctx.Has("user") {
+		userID = ctx.String("user")
+	}
+	now := time.Now()
+
+	voiceMutex.Lock()
+	activity := guildVoiceActivity(ctx.GuildID)
+	week := activity.timeSince(userID, startOfWeek(now), now)
+	total := activity.Totals[userID]
+	var current string
+	if member, ok := activity.Members[userID]; ok {
+		total += now.Sub(member.JoinedAt)
+		current = "\n" + ctx.T("voice.in_channel", member.ChannelID, formatDuration(now.Sub(member.JoinedAt)))
+	}
+	voiceMutex.Unlock()
+
+	ctx.Say("voice.stats", memberName(ctx.Session, ctx.GuildID, userID), formatDuration(week), formatDuration(total), current)
+}
+
+func showVoiceLeaderboard(ctx *Context) {
+	type entry struct {
+		UserID string
+		Time   time.Duration
+	}
+	now := time.Now()
+	since := startOfWeek(now)
+
+	voiceMutex.Lock()
+	activity := guildVoiceActivity(ctx.GuildID)
+	users := map[string]bool{}
+	for _, session := range activity.Sessions {
+		users[session.UserID] = true
+	}
+	for userID := range activity.Members {
+		users[userID] = true
+	}
+	var entries []entry
+	for userID := range users {
+		if t := activity.timeSince(userID, since, now); t > 0 {
+			entries = append(entries, entry{userID, t})
+		}
+	}
+	voiceMutex.Unlock()
+
+	if len(entries) == 0 {
+		ctx.Say("voice.nobody")
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time > entries[j].Time })
+
+	var board []string
+	for i, e := range entries {
+		board = append(board, fmt.Sprintf("%d) %s — %s", i+1, memberName(ctx.Session, ctx.GuildID, e.UserID), formatDuration(e.Time)))
+	}
+	paginateLines(ctx, ctx.T("voice.leaderboard", since.Format(ctx.T("date.short"))), board)
+}
+
+// showAFKReport lists members currently sitting muted, deafened or in the
+// guild's AFK channel, longest first.
+func showAFKReport(ctx *Context) {
+	type entry struct {
+		UserID string
+		Time   time.Duration
+	}
+	afkChannel := ""
+	if guild, err := ctx.Session.State.Guild(ctx.GuildID); err == nil {
+		afkChannel = guild.AfkChannelID
+	}
+	now := time.Now()
+
+	voiceMutex.Lock()
+	var entries []entry
+	for userID, member := range guildVoiceActivity(ctx.GuildID).Members {
+		switch {
+		case afkChannel != "" && member.ChannelID == afkChannel:
+			entries = append(entries, entry{userID, now.Sub(member.JoinedAt)})
+		case member.Muted:
+			entries = append(entries, entry{userID, now.Sub(member.MutedSince)})
+		}
+	}
+	voiceMutex.Unlock()
+
+	if len(entries) == 0 {
+		ctx.Say("afk.nobody")
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time > entries[j].Time })
+
+	var report []string
+	for i, e := range entries {
+		report = append(report, ctx.T("afk.line", i+1, memberName(ctx.Session, ctx.GuildID, e.UserID), formatDuration(e.Time)))
+	}
+	paginateLines(ctx, ctx.T("afk.title"), report)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestVoiceReconcile(t *testing.T) {
+	start := time.Unix(1000000, 0)
+	saved := start.Add(time.Hour)
+	now := start.Add(5 * time.Hour)
+	newActivity := func() *voiceActivity {
+		return &voiceActivity{
+			Members: map[string]*voiceMember{
+				"stayed": {ChannelID: "c", JoinedAt: start},
+				"left":   {ChannelID: "c", JoinedAt: start, Muted: true, MutedSince: start.Add(30 * time.Minute)},
+				"late":   {ChannelID: "c", JoinedAt: saved.Add(10 * time.Second)},
+			},
+			Totals:  map[string]time.Duration{},
+			SavedAt: saved,
+		}
+	}
+	present := map[string]*discordgo.VoiceState{
+		"stayed": {UserID: "stayed", ChannelID: "c"},
+		"late":   {UserID: "late", ChannelID: "c"},
+	}
+
+	// After a restart the downtime isn't voice time for anyone.
+	a := newActivity()
+	a.reconcile(present, now, true)
+	for userID, want := range map[string]time.Duration{"stayed": time.Hour, "left": time.Hour, "late": 0} {
+		if got := a.Totals[userID]; got != want {
+			t.Errorf("after restart %s has %v, want %v", userID, got, want)
+		}
+	}
+	if got := a.Sessions[0].MutedFor + a.Sessions[1].MutedFor + a.Sessions[2].MutedFor; got != 30*time.Minute {
+		t.Errorf("muted for %v, want 30m", got)
+	}
+	if len(a.Members) != 2 || !a.Members["stayed"].JoinedAt.Equal(now) {
+		t.Errorf("members = %v, want stayed and late starting over", a.Members)
+	}
+
+	// On a reconnect those still there keep their sessions.
+	a = newActivity()
+	a.reconcile(present, now, false)
+	if got := a.Totals["left"]; got != 5*time.Hour {
+		t.Errorf("left has %v, want 5h", got)
+	}
+	if len(a.Members) != 2 || !a.Members["stayed"].JoinedAt.Equal(start) {
+		t.Errorf("members = %v, want stayed keeping its session", a.Members)
+	}
+}
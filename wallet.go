@@ -0,0 +1,34 @@
+package main
+
+import "sync"
+
+// Virtual currency, one balance per guild member.
+var (
+	wallets     = map[string]map[string]int{}
+	walletMutex sync.Mutex
+)
+
+func walletBalance(guildID string, userID string) int {
+	walletMutex.Lock()
+	defer walletMutex.Unlock()
+	return wallets[guildID][userID]
+}
+
+func addToWallet(guildID string, userID string, amount int) error {
+	walletMutex.Lock()
+	defer walletMutex.Unlock()
+
+	if wallets[guildID] == nil {
+		wallets[guildID] = map[string]int{}
+	}
+	wallets[guildID][userID] += amount
+	return saveData("wallet", wallets)
+}
+
+func showBalance(ctx *Context) {
+	userID := ctx.Author.ID
+	if ctx.Has("user") {
+		userID = ctx.String("user")
+	}
+	ctx.Say("wallet.balance", walletBalance(ctx.GuildID, userID), memberName(ctx.Session, ctx.GuildID, userID))
+}